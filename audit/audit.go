@@ -0,0 +1,52 @@
+// Package audit contains the records of the audit log of yuppie and sinks
+// that these records can be written to
+package audit
+
+import (
+	"time"
+)
+
+// Record represents an entry of the audit log. It's created for each call of
+// a SOAP action and for each event subscription request
+type Record struct {
+	// Time is the point in time when the request was received
+	Time time.Time `json:"time"`
+	// RemoteAddr is the network address of the control point
+	RemoteAddr string `json:"remoteAddr"`
+	// UserAgent is the value of the USER-AGENT header field
+	UserAgent string `json:"userAgent,omitempty"`
+	// Service is the id of the service
+	Service string `json:"service,omitempty"`
+	// Action is the name of the SOAP action. For event subscription requests,
+	// it's SUBSCRIBE, RENEW or UNSUBSCRIBE
+	Action string `json:"action,omitempty"`
+	// Inputs contains the input arguments of the action. For event
+	// subscription requests, it contains the relevant header fields
+	Inputs map[string]string `json:"inputs,omitempty"`
+	// Outputs contains the output arguments of the action. For event
+	// subscription requests, it contains the relevant header fields of the
+	// response
+	Outputs map[string]string `json:"outputs,omitempty"`
+	// Status is the HTTP status of the response
+	Status int `json:"status"`
+	// FaultCode is the UPnP error code if a SOAP fault was sent
+	FaultCode int `json:"faultCode,omitempty"`
+	// FaultDesc is the error description if a SOAP fault was sent
+	FaultDesc string `json:"faultDesc,omitempty"`
+	// Duration is the time it took to process the request
+	Duration time.Duration `json:"duration"`
+}
+
+// Sink is the destination that audit records are written to
+type Sink interface {
+	Write(Record) error
+}
+
+// Func is a sink that calls a function for each audit record
+type Func func(Record)
+
+// Write calls me for the audit record rec
+func (me Func) Write(rec Record) error {
+	me(rec)
+	return nil
+}
@@ -0,0 +1,163 @@
+package audit
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// File is a sink that writes audit records as JSON lines to a file. If the
+// file exceeds a maximum size, it's rotated: path is renamed to path.1, path.1
+// to path.2 etc. Only a maximum number of such backups are kept
+type File struct {
+	path       string
+	maxSize    int64
+	maxBackups int
+	f          *os.File
+	size       int64
+	mu         sync.Mutex
+}
+
+// NewFile creates a sink that writes to the file path. If the file exists
+// already, the records are appended. maxSize is the maximum size of the file in
+// bytes (0 means that the file is not rotated), maxBackups the maximum number
+// of rotated files that are kept
+func NewFile(path string, maxSize int64, maxBackups int) (file *File, err error) {
+	file = &File{
+		path:       path,
+		maxSize:    maxSize,
+		maxBackups: maxBackups,
+	}
+	if err = file.open(); err != nil {
+		return nil, err
+	}
+	return
+}
+
+// Write writes the audit record rec as JSON line to the file
+func (me *File) Write(rec Record) (err error) {
+	line, err := json.Marshal(rec)
+	if err != nil {
+		err = errors.Wrap(err, "cannot marshal audit record")
+		return
+	}
+	line = append(line, '\n')
+
+	me.mu.Lock()
+	defer me.mu.Unlock()
+
+	if me.f == nil {
+		err = fmt.Errorf("audit file %s is closed", me.path)
+		return
+	}
+
+	// note: If the rotation fails, the record is written to the current file
+	// nevertheless. The rotation is retried with the next record
+	var errRotate error
+	if me.maxSize > 0 && me.size > 0 && me.size+int64(len(line)) > me.maxSize {
+		errRotate = me.rotate()
+	}
+
+	n, err := me.f.Write(line)
+	me.size += int64(n)
+	if err != nil {
+		err = errors.Wrapf(err, "cannot write to audit file %s", me.path)
+		return
+	}
+	return errRotate
+}
+
+// Close closes the file
+func (me *File) Close() (err error) {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+
+	if me.f == nil {
+		return
+	}
+	err = me.f.Close()
+	me.f = nil
+	return
+}
+
+// open opens the file for appending and determines its size
+func (me *File) open() (err error) {
+	f, size, err := openFile(me.path, os.O_APPEND)
+	if err != nil {
+		return
+	}
+	me.f, me.size = f, size
+	return
+}
+
+// openFile opens the file path for writing with the additional flags flag and
+// returns it together with its size
+func openFile(path string, flag int) (f *os.File, size int64, err error) {
+	if f, err = os.OpenFile(path, os.O_CREATE|os.O_WRONLY|flag, 0644); err != nil {
+		err = errors.Wrapf(err, "cannot open audit file %s", path)
+		return
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		err = errors.Wrapf(err, "cannot determine size of audit file %s", path)
+		return
+	}
+	size = info.Size()
+	return
+}
+
+// rotate replaces the file by a new, empty one and renames the existing
+// backups. The current file becomes the first backup. The new file is created
+// under a temporary name before anything is renamed. The current file is only
+// closed if the rotation was successful. Otherwise, it's still used for
+// writing
+func (me *File) rotate() (err error) {
+	tmpPath := me.path + ".new"
+	f, _, err := openFile(tmpPath, os.O_TRUNC)
+	if err != nil {
+		err = errors.Wrapf(err, "cannot rotate audit file %s", me.path)
+		return
+	}
+	abort := func() {
+		f.Close()
+		_ = os.Remove(tmpPath)
+	}
+
+	if me.maxBackups > 0 {
+		// note: The oldest backup is overwritten by the rename
+		for i := me.maxBackups - 1; i > 0; i-- {
+			from := fmt.Sprintf("%s.%d", me.path, i)
+			if _, err := os.Stat(from); err != nil {
+				continue
+			}
+			if err = os.Rename(from, fmt.Sprintf("%s.%d", me.path, i+1)); err != nil {
+				abort()
+				err = errors.Wrapf(err, "cannot rotate audit file %s", from)
+				return
+			}
+		}
+		if err = os.Rename(me.path, me.path+".1"); err != nil {
+			abort()
+			err = errors.Wrapf(err, "cannot rotate audit file %s", me.path)
+			return
+		}
+	}
+
+	// note: If there are no backups, the current file is replaced
+	if err = os.Rename(tmpPath, me.path); err != nil {
+		abort()
+		err = errors.Wrapf(err, "cannot rotate audit file %s", me.path)
+		return
+	}
+
+	errClose := me.f.Close()
+	me.f, me.size = f, 0
+	if errClose != nil {
+		err = errors.Wrapf(errClose, "cannot close rotated audit file %s", me.path)
+	}
+	return
+}
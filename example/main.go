@@ -12,50 +12,29 @@ import (
 	"sync"
 
 	log "github.com/sirupsen/logrus"
-	n "gitlab.com/go-utilities/net"
 	"gitlab.com/mipimipi/yuppie"
 	"gitlab.com/mipimipi/yuppie/desc"
 )
 
-var (
-	rootMeta     string
-	rootChildren string
-	song1Meta    string
-	song2Meta    string
-)
-
-func init() {
-	// determine external IP address
-	addr, err := n.IPaddr()
-	if err != nil {
-		log.Panicf("could not determine own IP addr: %v", err)
-		panic(fmt.Sprintf("could not determine own IP addr: %v", err))
-	}
-
-	// assemble URL for music dir
-	musicURL := url.URL{
-		Scheme: "http",
-		Host:   addr.String() + ":8008",
-		Path:   "/music/",
-	}
-
-	// DIDL-Lite content for responses of Browse action
+// DIDL-Lite content for responses of Browse action. %s is replaced by the URL of
+// the music dir
+const (
 	song1Meta = `<item id="1" parentID="0" restricted="1">
-						<dc:title>Air Shores</dc:title>
-						<upnp:class>object.item.audioItem.musicTrack</upnp:class>
-						<res protocolInfo="http-get:*:audio/mpeg:*">` + musicURL.String() + `1.mp3</res>
-					</item>`
+					<dc:title>Air Shores</dc:title>
+					<upnp:class>object.item.audioItem.musicTrack</upnp:class>
+					<res protocolInfo="http-get:*:audio/mpeg:*">%[1]s1.mp3</res>
+				</item>`
 	song2Meta = `<item id="2" parentID="0" restricted="1">
 					<dc:title>Much Moves</dc:title>
 					<upnp:class>object.item.audioItem.musicTrack</upnp:class>
-					<res protocolInfo="http-get:*:audio/mpeg:*">` + musicURL.String() + `2.mp3</res>
+					<res protocolInfo="http-get:*:audio/mpeg:*">%[1]s2.mp3</res>
 				</item>`
 	rootMeta = `<container id="0" parentID="-1" restricted="1" searchable="0" childCount="2">
 					<dc:title>root</dc:title>
 					<upnp:class>object.container</upnp:class>
 				</container>`
 	rootChildren = song1Meta + song2Meta
-}
+)
 
 // set HTTP handler functions
 func setHTTPHandlers(srv *yuppie.Server) {
@@ -76,31 +55,13 @@ func setHTTPHandlers(srv *yuppie.Server) {
 }
 
 // setSOAPHandlers defines handler functions for the required actions of the
-// ContentDirectory service
+// ContentDirectory service. Note: Actions that only return the values of state
+// variables (GetSystemUpdateID, for example) are handled by yuppie, thus only
+// Browse needs a handler
 func setSOAPHandlers(srv *yuppie.Server) {
-	srv.SOAPHandleFunc("ContentDirectory", "GetSearchCapabilities",
-		func(reqArgs map[string]yuppie.StateVar) (yuppie.SOAPRespArgs, yuppie.SOAPError) {
-			return yuppie.SOAPRespArgs{"SearchCaps": ""}, yuppie.SOAPError{}
-		})
-	srv.SOAPHandleFunc("ContentDirectory", "GetSortCapabilities",
-		func(reqArgs map[string]yuppie.StateVar) (yuppie.SOAPRespArgs, yuppie.SOAPError) {
-			return yuppie.SOAPRespArgs{"SortCaps": ""}, yuppie.SOAPError{}
-		})
-	srv.SOAPHandleFunc("ContentDirectory", "GetFeatureList",
-		func(reqArgs map[string]yuppie.StateVar) (yuppie.SOAPRespArgs, yuppie.SOAPError) {
-			return yuppie.SOAPRespArgs{"FeatureList": ""}, yuppie.SOAPError{}
-		})
-	srv.SOAPHandleFunc("ContentDirectory", "GetSystemUpdateID",
-		func(reqArgs map[string]yuppie.StateVar) (yuppie.SOAPRespArgs, yuppie.SOAPError) {
-			return yuppie.SOAPRespArgs{"Id": "1"}, yuppie.SOAPError{}
-		})
-	srv.SOAPHandleFunc("ContentDirectory", "GetServiceResetToken",
-		func(reqArgs map[string]yuppie.StateVar) (yuppie.SOAPRespArgs, yuppie.SOAPError) {
-			return yuppie.SOAPRespArgs{"ResetToken": "1"}, yuppie.SOAPError{}
-		})
-	srv.SOAPHandleFunc("ContentDirectory", "Browse",
-		func(reqArgs map[string]yuppie.StateVar) (yuppie.SOAPRespArgs, yuppie.SOAPError) {
-			return browse(reqArgs)
+	srv.SOAPHandle("ContentDirectory", "Browse",
+		func(_ context.Context, req *yuppie.SOAPRequest) (yuppie.SOAPRespArgs, yuppie.SOAPError) {
+			return browse(req)
 		})
 }
 
@@ -134,10 +95,18 @@ func initStateVariables(srv *yuppie.Server) {
 }
 
 // browse implements the Browse action of the ContentDirectory service
-func browse(reqArgs map[string]yuppie.StateVar) (respArgs yuppie.SOAPRespArgs, soapErr yuppie.SOAPError) {
-	// retrieve and check input arguments
-	objID, exists := reqArgs["ObjectID"]
-	if !exists || (objID.String() != "0" && objID.String() != "1" && objID.String() != "2") {
+func browse(req *yuppie.SOAPRequest) (respArgs yuppie.SOAPRespArgs, soapErr yuppie.SOAPError) {
+	reqArgs := req.Args
+
+	// assemble URL for music dir. It depends on the network interface the
+	// request came in on
+	musicURL := *req.BaseURL
+	musicURL.Path = "/music/"
+
+	// retrieve and check input arguments. Note: The server makes sure that
+	// all input arguments are contained in the request
+	objID := reqArgs["ObjectID"]
+	if objID.String() != "0" && objID.String() != "1" && objID.String() != "2" {
 		fmt.Printf("invalid ObjectID argument in browse action: '%s'", objID)
 		soapErr = yuppie.SOAPError{
 			Code: yuppie.UPnPErrorInvalidArgs,
@@ -145,12 +114,12 @@ func browse(reqArgs map[string]yuppie.StateVar) (respArgs yuppie.SOAPRespArgs, s
 		}
 		return
 	}
-	mode, exists := reqArgs["BrowseFlag"]
-	if !exists || (mode.String() != "BrowseDirectChildren" && mode.String() != "BrowseMetadata") {
-		fmt.Printf("invalid BrowseFlag argument in browse action: '%s'", objID)
+	mode := reqArgs["BrowseFlag"]
+	if mode.String() != "BrowseDirectChildren" && mode.String() != "BrowseMetadata" {
+		fmt.Printf("invalid BrowseFlag argument in browse action: '%s'", mode)
 		soapErr = yuppie.SOAPError{
 			Code: yuppie.UPnPErrorInvalidArgs,
-			Desc: fmt.Sprintf("invalid BrowseFlag argument in browse action: '%s'", objID),
+			Desc: fmt.Sprintf("invalid BrowseFlag argument in browse action: '%s'", mode),
 		}
 		return
 	}
@@ -161,18 +130,18 @@ func browse(reqArgs map[string]yuppie.StateVar) (respArgs yuppie.SOAPRespArgs, s
 	fmt.Fprint(buf, `<DIDL-Lite xmlns:dc="http://purl.org/dc/elements/1.1/" xmlns:upnp="urn:schemas-upnp-org:metadata-1-0/upnp/" xmlns="urn:schemas-upnp-org:metadata-1-0/DIDL-Lite/" xmlns:dlna="urn:schemas-dlna-org:metadata-1-0/">`)
 	switch objID.String() {
 	case "0":
-		if mode.String() == "BrowseMeta" {
+		if mode.String() == "BrowseMetadata" {
 			_, _ = buf.WriteString(rootMeta)
 			number = 1
 		} else {
-			_, _ = buf.WriteString(rootChildren)
+			fmt.Fprintf(buf, rootChildren, musicURL.String())
 			number = 2
 		}
 	case "1":
-		_, _ = buf.WriteString(song1Meta)
+		fmt.Fprintf(buf, song1Meta, musicURL.String())
 		number = 1
 	case "2":
-		_, _ = buf.WriteString(song2Meta)
+		fmt.Fprintf(buf, song2Meta, musicURL.String())
 		number = 1
 	}
 	fmt.Fprint(buf, `</DIDL-Lite>`)
@@ -1,13 +1,23 @@
 // Package main generates the Go types that implement the UPnP types i4, ui2,
 // string, fixed.14.4 etc. It generates the file yuppie/types_gen.go
 // based on the mapping configuration at the beginning of the main function.
-// To (re-)generate the Go types, go into the directory yuppie/gen and
-// execute ./gen on the command line.
+// To (re-)generate the Go types, go into the directory yuppie/gen, build gen
+// with go build and execute ./gen on the command line.
 // Note: If a new mapping is introduced, corresponding functions for marshalling
 // and unmarshalling must be available in ../conversion.go.
+//
+// Besides, gen generates typed skeletons for services from service
+// descriptions (SCPD files): A Go interface with one method per action, typed
+// request and response structs per action and a function that registers an
+// implementation of the interface at a yuppie server. Execute
+//
+//	./gen -scpd <SCPD-FILE> -service <SERVICE-ID> -pkg <PACKAGE> -out <OUTPUT-FILE>
+//
+// to generate such a skeleton.
 package main
 
 import (
+	"flag"
 	"fmt"
 	"os"
 
@@ -32,6 +42,12 @@ type Data struct {
 }
 
 func main() {
+	scpdFile := flag.String("scpd", "", "service description file to generate a service skeleton from")
+	svcID := flag.String("service", "", "service id (required with -scpd)")
+	pkg := flag.String("pkg", "main", "package of the generated service skeleton")
+	outFile := flag.String("out", "", "output file for the generated service skeleton (required with -scpd)")
+	flag.Parse()
+
 	// mapping between SOAP and Go types
 	data := Data{
 		[]upnp2Go{
@@ -62,6 +78,19 @@ func main() {
 		},
 	}
 
+	// generate service skeleton if a service description was given
+	if *scpdFile != "" {
+		if *svcID == "" || *outFile == "" {
+			fmt.Println("-service and -out are required with -scpd")
+			os.Exit(1)
+		}
+		if err := generateService(*scpdFile, *svcID, *pkg, *outFile, data.TypeMapping); err != nil {
+			fmt.Println(err.Error())
+			os.Exit(1)
+		}
+		return
+	}
+
 	// create output file
 	out, err := os.Create("../types_gen.go")
 	if err != nil {
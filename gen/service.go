@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"os"
+	"sort"
+	"strings"
+	"unicode"
+
+	"gitlab.com/mipimipi/yuppie/desc"
+)
+
+// svcArg represents an argument of an action for the generation of a service
+// skeleton
+type svcArg struct {
+	// Name is the argument name from the service description
+	Name string
+	// Field is the name of the corresponding Go struct field
+	Field    string
+	UPnPType string
+	GoType   string
+	// Raw is true if the value is passed as string since there's no exported
+	// Go type for the UPnP type
+	Raw bool
+}
+
+// svcAction represents an action for the generation of a service skeleton
+type svcAction struct {
+	// Name is the action name from the service description
+	Name string
+	// Method is the name of the corresponding Go method
+	Method string
+	In     []svcArg
+	Out    []svcArg
+}
+
+// svcData contains the data to be passed to the template engine for the
+// generation of a service skeleton
+type svcData struct {
+	Package   string
+	ServiceID string
+	Interface string
+	Imports   []string
+	Actions   []svcAction
+}
+
+// goIdent turns s into an exported Go identifier
+func goIdent(s string) string {
+	r := []rune(s)
+	for i := range r {
+		if !unicode.IsLetter(r[i]) && !unicode.IsDigit(r[i]) {
+			r[i] = '_'
+		}
+	}
+	if len(r) == 0 || !unicode.IsLetter(r[0]) {
+		r = append([]rune{'X'}, r...)
+	}
+	r[0] = unicode.ToUpper(r[0])
+	return string(r)
+}
+
+// newSvcData assembles the template data for the service with the id svcID
+// from the service description svc. mapping contains the mapping between UPnP
+// and Go types
+func newSvcData(svc *desc.Service, svcID, pkg string, mapping []upnp2Go) (data svcData, err error) {
+	goTypes := make(map[string]string)
+	for _, m := range mapping {
+		goTypes[m.UPnPType] = m.GoType
+	}
+
+	svTypes := make(map[string]string)
+	for _, sv := range svc.ServiceStateTable {
+		svTypes[strings.TrimSpace(sv.Name)] = strings.TrimSpace(sv.DataType)
+	}
+
+	data.Package = pkg
+	data.ServiceID = svcID
+	data.Interface = goIdent(svcID)
+
+	imports := make(map[string]bool)
+	for _, act := range svc.Actions {
+		a := svcAction{
+			Name:   strings.TrimSpace(act.Name),
+			Method: goIdent(strings.TrimSpace(act.Name)),
+		}
+		for _, arg := range act.Arguments {
+			typ, exists := svTypes[strings.TrimSpace(arg.RelatedStateVariable)]
+			if !exists {
+				err = fmt.Errorf("state variable '%s' for argument '%s' of action '%s' not found", arg.RelatedStateVariable, arg.Name, act.Name)
+				return
+			}
+			goType, exists := goTypes[typ]
+			if !exists {
+				err = fmt.Errorf("unknown type '%s' of state variable '%s'", typ, arg.RelatedStateVariable)
+				return
+			}
+			a0 := svcArg{
+				Name:     strings.TrimSpace(arg.Name),
+				Field:    goIdent(strings.TrimSpace(arg.Name)),
+				UPnPType: typ,
+				GoType:   goType,
+			}
+			switch goType {
+			case "timeOfDay":
+				// timeOfDay is not exported by yuppie
+				a0.GoType = "string"
+				a0.Raw = true
+			case "time.Time":
+				imports["time"] = true
+			case "*url.URL":
+				imports["net/url"] = true
+			}
+			if strings.ToLower(strings.TrimSpace(arg.Direction)) == "in" {
+				a.In = append(a.In, a0)
+			} else {
+				a.Out = append(a.Out, a0)
+			}
+		}
+		data.Actions = append(data.Actions, a)
+	}
+
+	for imp := range imports {
+		data.Imports = append(data.Imports, imp)
+	}
+	sort.Strings(data.Imports)
+
+	return
+}
+
+// generateService generates a Go file that contains a typed skeleton for the
+// service with the id svcID based on the service description file scpdFile.
+// The generated code belongs to the package pkg and is written to outFile
+func generateService(scpdFile, svcID, pkg, outFile string, mapping []upnp2Go) (err error) {
+	svc, err := desc.LoadService(scpdFile)
+	if err != nil {
+		return
+	}
+	if ok, res := svc.Validate(); !ok {
+		return fmt.Errorf("invalid service description: %s", res[0])
+	}
+
+	data, err := newSvcData(svc, svcID, pkg, mapping)
+	if err != nil {
+		return
+	}
+
+	buf := new(bytes.Buffer)
+	if err = svcTmpl.Execute(buf, data); err != nil {
+		return
+	}
+	src, err := format.Source(buf.Bytes())
+	if err != nil {
+		return fmt.Errorf("cannot format generated code: %v", err)
+	}
+
+	return os.WriteFile(outFile, src, 0644)
+}
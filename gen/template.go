@@ -2,6 +2,7 @@ package main
 
 import (
 	"html/template"
+	textTemplate "text/template"
 )
 
 var typesTmpl = template.Must(template.New("types").Parse(`
@@ -26,6 +27,18 @@ var constructors = map[string]func(string) (StateVar, error){ {{range .TypeMappi
 	"{{.UPnPType}}": func(v string) (StateVar, error) { return new{{.TypeNameTitle}}(v) },{{end}}
 }
 
+// marshalers maps UPnP types to functions that marshal values of the
+// corresponding Go types
+var marshalers = map[string]func(interface{}) (string, error){ {{range .TypeMapping}}
+	"{{.UPnPType}}": func(v interface{}) (string, error) {
+		val, ok := v.({{.GoType}})
+		if !ok {
+			return "", fmt.Errorf("expected type {{.GoType}}, received: %s", reflect.TypeOf(v))
+		}
+		return marshal{{.TypeNameTitle}}(val)
+	},{{end}}
+}
+
 {{range .TypeMapping}}
 // {{.TypeName}} is the representation of the UPnP type {{.UPnPType}} as Golang type
 type {{.TypeName}} struct {
@@ -107,3 +120,72 @@ func isNumeric(val interface{}) bool {
 func isString(val interface{}) bool { return reflect.ValueOf(val).Kind() == reflect.String }
 `,
 ))
+
+var svcTmpl = textTemplate.Must(textTemplate.New("service").Parse(`
+// ***********************************************************
+// GENERATED FILE - DO NOT EDIT BY HAND.
+// ***********************************************************
+
+package {{.Package}}
+
+import (
+	"context"
+{{range .Imports}}	"{{.}}"
+{{end}}
+	"gitlab.com/mipimipi/yuppie"
+)
+
+// {{.Interface}} is the interface of the service {{.ServiceID}}. It contains one
+// method per action
+type {{.Interface}} interface { {{range .Actions}}
+	{{.Method}}(ctx context.Context, req *{{.Method}}Request) (*{{.Method}}Response, yuppie.SOAPError){{end}}
+}
+{{range .Actions}}
+// {{.Method}}Request contains the input arguments of the action {{.Name}}
+type {{.Method}}Request struct {
+	// Meta contains the action call including metadata of the HTTP request
+	Meta *yuppie.SOAPRequest{{range .In}}
+	{{.Field}} {{.GoType}}{{end}}
+}
+
+// {{.Method}}Response contains the output arguments of the action {{.Name}}
+type {{.Method}}Response struct { {{range .Out}}
+	{{.Field}} {{.GoType}}{{end}}
+}
+{{end}}
+// Register{{.Interface}} registers impl as handler for all actions of the
+// service {{.ServiceID}}
+func Register{{.Interface}}(srv *yuppie.Server, impl {{.Interface}}) { {{range .Actions}}
+	srv.SOAPHandle("{{$.ServiceID}}", "{{.Name}}",
+		func(ctx context.Context, req *yuppie.SOAPRequest) (yuppie.SOAPRespArgs, yuppie.SOAPError) {
+			in := &{{.Method}}Request{Meta: req}{{range .In}}
+			if arg, exists := req.Args["{{.Name}}"]; exists { {{if .Raw}}
+				in.{{.Field}} = arg.String(){{else}}
+				v, ok := arg.Get().({{.GoType}})
+				if !ok {
+					return nil, yuppie.SOAPError{Code: yuppie.UPnPErrorInvalidArgs, Desc: "argument '{{.Name}}' has an invalid type"}
+				}
+				in.{{.Field}} = v{{end}}
+			}{{end}}
+
+			out, soapErr := impl.{{.Method}}(ctx, in)
+			if !soapErr.IsNil() {
+				return nil, soapErr
+			}
+			if out == nil {
+				out = new({{.Method}}Response)
+			}
+{{if .Out}}
+			var err error
+			args := make(yuppie.SOAPRespArgs){{range .Out}}{{if .Raw}}
+			args["{{.Name}}"] = out.{{.Field}}{{else}}
+			if args["{{.Name}}"], err = yuppie.MarshalValue("{{.UPnPType}}", out.{{.Field}}); err != nil {
+				return nil, yuppie.SOAPError{Code: yuppie.UPnPErrorActionFailed, Desc: err.Error()}
+			}{{end}}{{end}}
+			return args, yuppie.SOAPError{}{{else}}
+			return yuppie.SOAPRespArgs{}, yuppie.SOAPError{}{{end}}
+		},
+	){{end}}
+}
+`,
+))
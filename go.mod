@@ -10,7 +10,6 @@ require (
 	gitlab.com/go-utilities/file v0.2.0
 	gitlab.com/go-utilities/filepath v0.1.0
 	gitlab.com/go-utilities/hash v0.1.0
-	gitlab.com/go-utilities/net v0.1.0
 	gitlab.com/go-utilities/reflect v0.1.0
 	gitlab.com/go-utilities/system v0.1.0
 	gitlab.com/go-utilities/time v0.1.0
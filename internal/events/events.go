@@ -12,7 +12,6 @@ import (
 	"time"
 
 	"github.com/google/uuid"
-	"github.com/pkg/errors"
 	l "github.com/sirupsen/logrus"
 	t "gitlab.com/go-utilities/time"
 	"gitlab.com/mipimipi/yuppie/internal/network"
@@ -67,14 +66,17 @@ type Eventing struct {
 	stop       chan struct{}
 	mutChanges *sync.Mutex
 	mutSubs    *sync.Mutex
-	infs       []net.Interface
+	infs       []network.Interface
 	bootID     *types.BootID
+	chunkSize  int
 }
 
-// NewEventing creates an Eventing instance. wanted contains the list of network
-// interfaces that where configured, booID is a function that returns the current
-// BootID. if no interfaces are configured, all interfaces are used
-func NewEventing(wanted []string, bootID *types.BootID) (evt *Eventing, err error) {
+// NewEventing creates an Eventing instance. infs contains the network
+// interfaces that are used for multicast eventing, booID is a function that
+// returns the current BootID. If chunkSize is greater than 0, event messages
+// that are larger than chunkSize bytes are sent to all subscribers with chunked
+// transfer encoding in chunks of chunkSize bytes
+func NewEventing(infs []network.Interface, bootID *types.BootID, chunkSize int) (evt *Eventing) {
 	evt = new(Eventing)
 
 	evt.Listener = make(chan StateVar)
@@ -84,11 +86,8 @@ func NewEventing(wanted []string, bootID *types.BootID) (evt *Eventing, err erro
 	evt.mutSubs = new(sync.Mutex)
 
 	evt.bootID = bootID
-	evt.infs, err = network.Interfaces(wanted)
-	if err != nil {
-		err = errors.Wrap(err, "cannot determine network interfaces for eventing")
-		return
-	}
+	evt.infs = infs
+	evt.chunkSize = chunkSize
 
 	return
 }
@@ -196,6 +195,7 @@ func (me *Eventing) AddSub(dur time.Duration, urls []*url.URL, svs []StateVar) (
 		),
 		urls:      urls,
 		stateVars: svs,
+		chunkSize: me.chunkSize,
 	}
 	me.mutSubs.Lock()
 	me.subs[sid] = &sub
@@ -278,7 +278,7 @@ func (me *Eventing) sendMulticast(svs []StateVar) {
 }
 
 // broadcast sends an event message for one state variable via all interfaces
-func broadcast(key uint32, sv StateVar, infs []net.Interface, bootID uint32) {
+func broadcast(key uint32, sv StateVar, infs []network.Interface, bootID uint32) {
 	log.Tracef("broadcasting state variable '%s' with key %d ...", sv.Name(), key)
 
 	// assemble message body
@@ -311,7 +311,7 @@ func broadcast(key uint32, sv StateVar, infs []net.Interface, bootID uint32) {
 		}
 		// send event message from all interfaces
 		for _, inf := range infs {
-			conn, err := network.UDPConn(inf, multicastUDPAddr)
+			conn, err := network.UDPConn(inf.Interface, multicastUDPAddr)
 			if err != nil {
 				log.Errorf("could not create connection for multicast eventing: %v", err)
 				continue
@@ -28,6 +28,10 @@ type Subscription struct {
 	urls      []*url.URL
 	stateVars []StateVar
 	sequence  uint32
+	// chunkSize is the size of chunks for chunked transfer encoding. Event
+	// messages are only sent chunked if it's greater than 0 and their body is
+	// larger than chunkSize bytes. Otherwise, CONTENT-LENGTH is used
+	chunkSize int
 }
 
 // sendEvent sends an event to the recipient of this subscription. As the UPnP
@@ -48,14 +52,23 @@ func (me *Subscription) sendEvent() {
 		fmt.Fprintf(msg, "NOTIFY %s HTTP/1.1\r\n", u.Path)
 		fmt.Fprintf(msg, "HOST: %s:%s\r\n", u.Hostname(), u.Port())
 		fmt.Fprint(msg, "CONTENT-TYPE: text/xml; charset=\"utf-8\"\r\n")
-		fmt.Fprintf(msg, "CONTENT-LENGTH: %d\r\n", len(body))
+		chunked := me.chunkSize > 0 && len(body) > me.chunkSize
+		if chunked {
+			fmt.Fprint(msg, "TRANSFER-ENCODING: chunked\r\n")
+		} else {
+			fmt.Fprintf(msg, "CONTENT-LENGTH: %d\r\n", len(body))
+		}
 		fmt.Fprint(msg, "NT: upnp:event\r\n")
 		fmt.Fprint(msg, "NTS: upnp:propchange\r\n")
 		fmt.Fprintf(msg, "SID: uuid:%s\r\n", me.sid.String())
 		fmt.Fprintf(msg, "SEQ: %d\r\n", me.sequence)
 		fmt.Fprint(msg, "\r\n")
-		fmt.Fprint(msg, string(body))
-		fmt.Fprint(msg, "\r\n")
+		if chunked {
+			writeChunked(msg, body, me.chunkSize)
+		} else {
+			_, _ = msg.Write(body)
+			fmt.Fprint(msg, "\r\n")
+		}
 
 		// create TCP connection
 		var conn net.Conn
@@ -81,6 +94,23 @@ func (me *Subscription) sendEvent() {
 	}
 }
 
+// writeChunked writes body to msg with chunked transfer encoding, i.e. in
+// chunks of (at most) size bytes that are preceded by their length. The last
+// chunk has length 0
+func writeChunked(msg *bytes.Buffer, body []byte, size int) {
+	for len(body) > 0 {
+		n := size
+		if n > len(body) {
+			n = len(body)
+		}
+		fmt.Fprintf(msg, "%x\r\n", n)
+		_, _ = msg.Write(body[:n])
+		fmt.Fprint(msg, "\r\n")
+		body = body[n:]
+	}
+	fmt.Fprint(msg, "0\r\n\r\n")
+}
+
 // ParseURLs parses the callback string that the recipient submitted as part of
 // the subscription request. If the string is not according to the required
 // format an error is returned. As defined in UPnP Device Architecture 2.0, the
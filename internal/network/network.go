@@ -3,6 +3,7 @@
 package network
 
 import (
+	"fmt"
 	"net"
 
 	"github.com/pkg/errors"
@@ -21,58 +22,101 @@ func init() {
 // message can be sent up to 3 times
 const UDPMsgRepetitions = 3
 
+// Interface represents a network interface together with the IPv4 address
+// that is used on that interface
+type Interface struct {
+	net.Interface
+	IP net.IP
+}
+
 // Interfaces returns the network interfaces that are available on the machine
-// (i.e. the interfaces that are up and that are no loopback). If wanted is not
-// empty, the content of that array is interpreted as interface names and only
-// these for these names the corresponding network interfaces are determined
-// and returned.
-func Interfaces(wanted []string) (infs []net.Interface, err error) {
-	// if interfaces have been configured: take them
-	// otherwise use all interfaces of this machine
-	var inf0s []net.Interface
-	if len(wanted) > 0 {
-		for _, name := range wanted {
-			inf, err := net.InterfaceByName(name)
-			if err != nil {
-				log.Errorf("cannot determine interface '%s': %v", name, err)
-				continue
-			}
-			inf0s = append(infs, *inf)
+// and that are selected by sel. Only interfaces that are up and that have an
+// IPv4 address are taken into account. Loopback interfaces are only returned
+// if they have one of the bind IPs of sel or if they are included explicitly.
+// The decision for or against each
+// interface is logged.
+func Interfaces(sel Selector) (infs []Interface, err error) {
+	log.Trace("get network interfaces of that machine")
+
+	inf0s, err := net.Interfaces()
+	if err != nil {
+		err = errors.Wrap(err, "cannot determine interfaces")
+		return
+	}
+	log.Tracef("found %d interfaces", len(inf0s))
+
+	for _, inf := range inf0s {
+		if inf.Flags&net.FlagUp == 0 || inf.MTU <= 0 {
+			log.Infof("interface %s not used: it is down", inf.Name)
+			continue
 		}
-	} else {
-		log.Trace("get network interfaces of that machine")
 
-		if inf0s, err = net.Interfaces(); err != nil {
-			err = errors.Wrap(err, "cannot determine interfaces")
-			return
+		ips := ipv4Addrs(inf)
+		if len(ips) == 0 {
+			log.Infof("interface %s not used: it has no IP4 address", inf.Name)
+			continue
 		}
-		log.Tracef("found %d interfaces", len(inf0s))
+
+		ip, reason, ok := sel.selects(inf, ips)
+		if !ok {
+			log.Infof("interface %s not used: %s", inf.Name, reason)
+			continue
+		}
+
+		log.Infof("interface %s used with address %s: %s", inf.Name, ip.String(), reason)
+		infs = append(infs, Interface{inf, ip})
 	}
 
-	// collect interfaces that
-	// (1) are up
-	// (2) are no loopback interface
-	// (3) have an ip4 address
-	for _, inf := range inf0s {
-		if inf.Flags&net.FlagUp == 0 || inf.Flags&net.FlagLoopback != 0 || inf.MTU <= 0 {
+	return
+}
+
+// ipv4Addrs returns the IPv4 addresses of the network interface inf
+func ipv4Addrs(inf net.Interface) (ips []net.IP) {
+	addrs, err := inf.Addrs()
+	if err != nil {
+		log.Errorf("cannot determine IP addresses of interface %s", inf.Name)
+		return
+	}
+	for _, addr := range addrs {
+		ipNet, ok := addr.(*net.IPNet)
+		if !ok {
 			continue
 		}
+		if ip := ipNet.IP.To4(); ip != nil {
+			ips = append(ips, ip)
+		}
+	}
+	return
+}
+
+// IPv4Addr returns the first IPv4 address of the network interface inf
+func IPv4Addr(inf net.Interface) (ip net.IP, err error) {
+	ips := ipv4Addrs(inf)
+	if len(ips) == 0 {
+		err = fmt.Errorf("interface %s has no IP4 address", inf.Name)
+		return
+	}
+	return ips[0], nil
+}
 
-		// get addresses of inf
-		addrs, err := inf.Addrs()
+// InterfaceByIP returns the network interface that has the IP address ip
+func InterfaceByIP(ip net.IP) (inf *net.Interface, err error) {
+	infs, err := net.Interfaces()
+	if err != nil {
+		err = errors.Wrap(err, "cannot determine interfaces")
+		return
+	}
+	for i := range infs {
+		addrs, err := infs[i].Addrs()
 		if err != nil {
-			log.Errorf("cannot determine IP addresses of interface %s", inf.Name)
 			continue
 		}
-		// check if one of these addresses is an ip4 address
-		for _, addr := range addrs { // get ipv4 address
-			ip4Addr := addr.(*net.IPNet).IP.To4()
-			if ip4Addr != nil {
-				infs = append(infs, inf)
-				break
+		for _, addr := range addrs {
+			if ipNet, ok := addr.(*net.IPNet); ok && ipNet.IP.Equal(ip) {
+				return &infs[i], nil
 			}
 		}
 	}
-
+	err = fmt.Errorf("no interface with IP address %s found", ip.String())
 	return
 }
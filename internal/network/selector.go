@@ -0,0 +1,173 @@
+package network
+
+import (
+	"fmt"
+	"net"
+	"path"
+)
+
+// DefaultExcludes contains name patterns of virtual interfaces (container
+// bridges etc.) that are not used if no include patterns are given. Such
+// interfaces would only lead to useless locations in SSDP messages
+var DefaultExcludes = []string{
+	"docker*",
+	"veth*",
+	"br-*",
+	"virbr*",
+	"cni*",
+	"flannel*",
+	"vboxnet*",
+	"vmnet*",
+}
+
+// Selector selects network interfaces. Include and Exclude contain patterns
+// which can be
+//   - interface names or name globs (e.g. "eth0" or "docker*")
+//   - CIDRs (e.g. "192.168.1.0/24")
+//   - IP addresses (e.g. "192.168.1.10")
+//
+// An interface is selected if it matches one of the include patterns (or if
+// there are no include patterns) and none of the exclude patterns. If there
+// are no include patterns, DefaultExcludes are applied additionally. If
+// BindIPs is not empty, only interfaces that have one of these addresses are
+// selected - loopback interfaces included. The patterns are applied to these
+// interfaces as well. Other loopback interfaces are only selected if they
+// match an include pattern
+type Selector struct {
+	Include []string
+	Exclude []string
+	BindIPs []net.IP
+}
+
+// pattern represents a pattern for the selection of network interfaces
+type pattern struct {
+	raw   string
+	ip    net.IP
+	ipNet *net.IPNet
+}
+
+// newPattern creates a pattern from the string s
+func newPattern(s string) (p pattern, err error) {
+	p.raw = s
+	if _, ipNet, e := net.ParseCIDR(s); e == nil {
+		p.ipNet = ipNet
+		return
+	}
+	if ip := net.ParseIP(s); ip != nil {
+		p.ip = ip
+		return
+	}
+	if _, err = path.Match(s, ""); err != nil {
+		err = fmt.Errorf("invalid interface pattern '%s'", s)
+	}
+	return
+}
+
+// match checks if the network interface inf with the IP addresses ips matches
+// the pattern. If that's the case, the IP address that matched (or - for name
+// patterns - the first IP address) is returned
+func (me pattern) match(inf net.Interface, ips []net.IP) (net.IP, bool) {
+	switch {
+	case me.ipNet != nil:
+		for _, ip := range ips {
+			if me.ipNet.Contains(ip) {
+				return ip, true
+			}
+		}
+	case me.ip != nil:
+		for _, ip := range ips {
+			if me.ip.Equal(ip) {
+				return ip, true
+			}
+		}
+	default:
+		if ok, _ := path.Match(me.raw, inf.Name); ok {
+			return ips[0], true
+		}
+	}
+	return nil, false
+}
+
+// Validate checks if the include and exclude patterns are valid
+func (me Selector) Validate() error {
+	for _, s := range append(append([]string{}, me.Include...), me.Exclude...) {
+		if _, err := newPattern(s); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// selects checks if the network interface inf with the IPv4 addresses ips is
+// selected. The IP address that shall be used on inf and the reason for the
+// decision are returned. Include and exclude patterns are applied to
+// interfaces with bind addresses as well. Loopback interfaces are only
+// selected if they have a bind address or match an include pattern
+func (me Selector) selects(inf net.Interface, ips []net.IP) (ip net.IP, reason string, ok bool) {
+	loopback := inf.Flags&net.FlagLoopback != 0
+
+	// bind IPs: if there are any, the interface must have one of them, and
+	// the patterns are only checked against that address
+	var bound bool
+	if len(me.BindIPs) > 0 {
+		for _, bindIP := range me.BindIPs {
+			if _, bound = (pattern{ip: bindIP}).match(inf, ips); bound {
+				ip, ips = bindIP, []net.IP{bindIP}
+				reason = fmt.Sprintf("has bind address %s", bindIP.String())
+				break
+			}
+		}
+		if !bound {
+			return nil, "has none of the bind addresses", false
+		}
+	}
+
+	// include patterns
+	if len(me.Include) == 0 {
+		if loopback && !bound {
+			return nil, "it is a loopback interface", false
+		}
+		if !bound {
+			ip, reason = ips[0], "all interfaces are used"
+		}
+	} else {
+		var included bool
+		for _, s := range me.Include {
+			p, err := newPattern(s)
+			if err != nil {
+				continue
+			}
+			var incIP net.IP
+			if incIP, included = p.match(inf, ips); included {
+				if !bound {
+					ip, reason = incIP, fmt.Sprintf("matches include pattern '%s'", s)
+				} else {
+					reason += fmt.Sprintf(" and matches include pattern '%s'", s)
+				}
+				break
+			}
+		}
+		// note: Loopback addresses that are bind addresses have been
+		// requested explicitly. Thus, they need not be included
+		if !included && !(loopback && bound) {
+			return nil, "matches no include pattern", false
+		}
+	}
+
+	// exclude patterns
+	excludes := me.Exclude
+	if len(me.Include) == 0 {
+		excludes = append(append([]string{}, excludes...), DefaultExcludes...)
+	}
+	for _, s := range excludes {
+		p, err := newPattern(s)
+		if err != nil {
+			continue
+		}
+		if _, excluded := p.match(inf, ips); excluded {
+			return nil, fmt.Sprintf("matches exclude pattern '%s'", s), false
+		}
+	}
+
+	return ip, reason, true
+}
@@ -0,0 +1,51 @@
+package network
+
+import (
+	"net"
+	"testing"
+)
+
+func TestSelectorSelects(t *testing.T) {
+	eth0 := net.Interface{Name: "eth0", Flags: net.FlagUp}
+	docker0 := net.Interface{Name: "docker0", Flags: net.FlagUp}
+	lo := net.Interface{Name: "lo", Flags: net.FlagUp | net.FlagLoopback}
+
+	ethIPs := []net.IP{net.IPv4(192, 168, 1, 10), net.IPv4(10, 0, 0, 10)}
+	dockerIPs := []net.IP{net.IPv4(172, 17, 0, 1)}
+	loIPs := []net.IP{net.IPv4(127, 0, 0, 1)}
+
+	tests := []struct {
+		name string
+		sel  Selector
+		inf  net.Interface
+		ips  []net.IP
+		ip   net.IP
+		ok   bool
+	}{
+		{"default", Selector{}, eth0, ethIPs, ethIPs[0], true},
+		{"default excludes virtual interfaces", Selector{}, docker0, dockerIPs, nil, false},
+		{"default excludes loopback", Selector{}, lo, loIPs, nil, false},
+		{"include name", Selector{Include: []string{"eth0"}}, eth0, ethIPs, ethIPs[0], true},
+		{"include glob", Selector{Include: []string{"eth*"}}, eth0, ethIPs, ethIPs[0], true},
+		{"include CIDR selects matching address", Selector{Include: []string{"10.0.0.0/8"}}, eth0, ethIPs, ethIPs[1], true},
+		{"include IP", Selector{Include: []string{"10.0.0.10"}}, eth0, ethIPs, ethIPs[1], true},
+		{"no include pattern matches", Selector{Include: []string{"wlan*"}}, eth0, ethIPs, nil, false},
+		{"include overrides default excludes", Selector{Include: []string{"docker0"}}, docker0, dockerIPs, dockerIPs[0], true},
+		{"include loopback", Selector{Include: []string{"lo"}}, lo, loIPs, loIPs[0], true},
+		{"exclude name", Selector{Exclude: []string{"eth0"}}, eth0, ethIPs, nil, false},
+		{"exclude beats include", Selector{Include: []string{"eth*"}, Exclude: []string{"192.168.1.0/24"}}, eth0, ethIPs, nil, false},
+		{"bind address", Selector{BindIPs: []net.IP{ethIPs[1]}}, eth0, ethIPs, ethIPs[1], true},
+		{"bind address of other interface", Selector{BindIPs: []net.IP{ethIPs[1]}}, docker0, dockerIPs, nil, false},
+		{"bind loopback address", Selector{BindIPs: []net.IP{loIPs[0]}}, lo, loIPs, loIPs[0], true},
+		{"bind address and matching include", Selector{Include: []string{"10.0.0.0/8"}, BindIPs: []net.IP{ethIPs[1]}}, eth0, ethIPs, ethIPs[1], true},
+		{"bind address and other include", Selector{Include: []string{"192.168.1.0/24"}, BindIPs: []net.IP{ethIPs[1]}}, eth0, ethIPs, nil, false},
+		{"bind address and exclude", Selector{Exclude: []string{"eth0"}, BindIPs: []net.IP{ethIPs[1]}}, eth0, ethIPs, nil, false},
+	}
+
+	for _, test := range tests {
+		ip, reason, ok := test.sel.selects(test.inf, test.ips)
+		if ok != test.ok || !ip.Equal(test.ip) {
+			t.Errorf("%s: selects returned %v, %t (%s), expected %v, %t", test.name, ip, ok, reason, test.ip, test.ok)
+		}
+	}
+}
@@ -26,6 +26,16 @@ func UDPConn(inf net.Interface, addr *net.UDPAddr) (conn *net.UDPConn, err error
 	return
 }
 
+// UnicastUDPConn creates a UDP network connection that listens for unicast
+// messages on IP address ip and port port
+func UnicastUDPConn(ip net.IP, port int) (conn *net.UDPConn, err error) {
+	if conn, err = net.ListenUDP("udp4", &net.UDPAddr{IP: ip, Port: port}); err != nil {
+		err = errors.Wrapf(err, "cannot listen to unicast UDP on %s:%d", ip.String(), port)
+		return
+	}
+	return
+}
+
 // SendUDP sends the message msg via connection conn to address addr
 func SendUDP(conn *net.UDPConn, addr *net.UDPAddr, msg []byte) (err error) {
 	var n int
@@ -1,9 +1,7 @@
 package ssdp
 
 import (
-	"bytes"
 	"fmt"
-	"strings"
 	"time"
 
 	"github.com/fwojciec/clock"
@@ -51,6 +49,10 @@ func (me *Server) notify() {
 	for {
 		select {
 		case <-ticker.C:
+			// no regular alive messages while the device is asleep
+			if me.isAsleep() {
+				continue
+			}
 			me.sendAlive()
 		case <-me.stopNotify:
 			close(me.notifyStopped)
@@ -60,62 +62,60 @@ func (me *Server) notify() {
 	}
 }
 
-// sendAlive sends an alive message
+// sendAlive sends alive messages
 func (me *Server) sendAlive() {
-	// send alive messages 3 times as required by the UPnP Device Architecture 2.0
-	for i := 0; i < network.UDPMsgRepetitions; i++ {
-		// sleep for a few hundert milliseconds as required by the UPnP Device
-		// Architecture 2.0
-		t.RandomNap(1000)
-		// send alive messages
-		for _, assID := range me.data.AssIDs {
-			msg := new(bytes.Buffer)
-			fmt.Fprint(msg, "NOTIFY * HTTP/1.1\r\n")
-			fmt.Fprintf(msg, "HOST: %s\r\n", multicastAddrIPv4)
-			fmt.Fprintf(msg, "NT: %s\r\n", assID.NT)
-			fmt.Fprintf(msg, "NTS: %s\r\n", "ssdp:alive")
-			fmt.Fprintf(msg, "USN: %s\r\n", assID.USN)
-			fmt.Fprintf(msg, "LOCATION: %s\r\n", strings.Replace(me.data.Location, "{{ADDRESS}}", me.addr, -1))
-			fmt.Fprintf(msg, "CACHE-CONTROL: max-age=%d\r\n", me.data.MaxAge)
-			fmt.Fprintf(msg, "BOOTID.UPNP.ORG: %d\r\n", me.bootID.Val())
-			fmt.Fprintf(msg, "CONFIG.UPNP.ORG: %d\r\n", me.configID.Val())
-			// add empty row at the end as required by the UPnP Device
-			// Architecture 2.0
-			fmt.Fprint(msg, "\r\n")
-
-			if err := network.SendUDP(me.conn, multicastUDPAddr, msg.Bytes()); err != nil {
-				continue
-			}
-		}
-	}
+	me.sendNotifications(me.aliveMsg)
 	log.Tracef("sent alive messages on interface '%s'", me.inf.Name)
 }
 
-// sendByeBye send a byebye message
+// sendByeBye sends byebye messages
 func (me *Server) sendByeBye() {
-	// send alive messages 3 times as required by the UPnP Device Architecture 2.0
+	me.sendNotifications(me.byeByeMsg)
+	log.Tracef("sent byebye messages on interface '%s'", me.inf.Name)
+}
+
+// sendNotifications sends one notification per asset to all targets. The
+// messages are created by the function create
+func (me *Server) sendNotifications(create func(host string, assID AssetID) *message) {
+	// send messages 3 times as required by the UPnP Device Architecture 2.0
 	for i := 0; i < network.UDPMsgRepetitions; i++ {
 		// sleep for a few hundert milliseconds as required by the UPnP Device
 		// Architecture 2.0
 		t.RandomNap(1000)
-		// send byebye messages
 		for _, assID := range me.data.AssIDs {
-			msg := new(bytes.Buffer)
-			fmt.Fprint(msg, "NOTIFY * HTTP/1.1\r\n")
-			fmt.Fprintf(msg, "HOST: %s\r\n", multicastAddrIPv4)
-			fmt.Fprintf(msg, "NT: %s\r\n", assID.NT)
-			fmt.Fprintf(msg, "NTS: %s\r\n", "ssdp:byebye")
-			fmt.Fprintf(msg, "USN: %s\r\n", assID.USN)
-			fmt.Fprintf(msg, "BOOTID.UPNP.ORG: %d\r\n", me.bootID.Val())
-			fmt.Fprintf(msg, "CONFIG.UPNP.ORG: %d\r\n", me.configID.Val())
-			// add empty row at the end as required by the UPnP Device
-			// Architecture 2.0
-			fmt.Fprint(msg, "\r\n")
-
-			if err := network.SendUDP(me.conn, multicastUDPAddr, msg.Bytes()); err != nil {
-				continue
+			for _, addr := range me.targets() {
+				msg := create(addr.String(), assID)
+				if err := network.SendUDP(me.conn, addr, msg.bytes()); err != nil {
+					continue
+				}
 			}
 		}
 	}
-	log.Tracef("sent byebye messages on interface '%s'", me.inf.Name)
+}
+
+// aliveMsg creates an alive message for the asset assID that is sent to host
+func (me *Server) aliveMsg(host string, assID AssetID) *message {
+	msg := newNotification(KindAlive, host)
+	msg.add("NT", assID.NT)
+	msg.add("NTS", "ssdp:alive")
+	msg.add("USN", assID.USN)
+	msg.add("LOCATION", me.data.Location)
+	msg.add("CACHE-CONTROL", fmt.Sprintf("max-age=%d", me.maxAge()))
+	msg.add("BOOTID.UPNP.ORG", fmt.Sprint(me.bootID.Val()))
+	msg.add("CONFIG.UPNP.ORG", fmt.Sprint(me.configID.Val()))
+	me.addPowerState(msg)
+	me.opts.Headers.apply(msg, assID.NT)
+	return msg
+}
+
+// byeByeMsg creates a byebye message for the asset assID that is sent to host
+func (me *Server) byeByeMsg(host string, assID AssetID) *message {
+	msg := newNotification(KindByeBye, host)
+	msg.add("NT", assID.NT)
+	msg.add("NTS", "ssdp:byebye")
+	msg.add("USN", assID.USN)
+	msg.add("BOOTID.UPNP.ORG", fmt.Sprint(me.bootID.Val()))
+	msg.add("CONFIG.UPNP.ORG", fmt.Sprint(me.configID.Val()))
+	me.opts.Headers.apply(msg, assID.NT)
+	return msg
 }
@@ -0,0 +1,91 @@
+package ssdp
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// header fields and power states as defined in the UPnP Low Power Architecture
+const (
+	hdrPowerState  = "Powerstate"
+	hdrSleepPeriod = "SleepPeriod"
+
+	powerStateActive          = 1
+	powerStateDeepSleepOnline = 3
+)
+
+// lowPower contains the low power status of an SSDP server
+type lowPower struct {
+	// used is true if the low power mode has been used at least once. From
+	// then on, the power state is contained in all alive messages
+	used   bool
+	asleep bool
+	period time.Duration
+	mut    sync.Mutex
+}
+
+// Sleep announces that the device goes to sleep for the time period period.
+// Alive messages that contain the power state and the sleep period are sent
+// immediately. Their max-age is extended to cover the sleep period, so that
+// the advertisements stay valid for control points and low power proxies.
+// While asleep, no further alive messages are sent regularly, but search
+// requests are still answered with the power state of the device
+func (me *Server) Sleep(period time.Duration) {
+	me.lp.mut.Lock()
+	me.lp.used = true
+	me.lp.asleep = true
+	me.lp.period = period
+	me.lp.mut.Unlock()
+
+	me.sendAlive()
+	log.Tracef("announced sleep on interface '%s'", me.inf.Name)
+}
+
+// Wake announces that the device woke up. Alive messages are sent immediately
+// and the regular alive notifications are resumed
+func (me *Server) Wake() {
+	me.lp.mut.Lock()
+	me.lp.asleep = false
+	me.lp.period = 0
+	me.lp.mut.Unlock()
+
+	me.sendAlive()
+	log.Tracef("announced wake-up on interface '%s'", me.inf.Name)
+}
+
+// isAsleep returns true if the device is asleep
+func (me *Server) isAsleep() bool {
+	me.lp.mut.Lock()
+	defer me.lp.mut.Unlock()
+	return me.lp.asleep
+}
+
+// maxAge returns the max-age for advertisements. While the device is asleep
+// it's at least the sleep period
+func (me *Server) maxAge() int {
+	me.lp.mut.Lock()
+	defer me.lp.mut.Unlock()
+
+	if me.lp.asleep && int(me.lp.period.Seconds()) > me.data.MaxAge {
+		return int(me.lp.period.Seconds())
+	}
+	return me.data.MaxAge
+}
+
+// addPowerState adds the power state header fields to msg if the low power
+// mode has been used
+func (me *Server) addPowerState(msg *message) {
+	me.lp.mut.Lock()
+	defer me.lp.mut.Unlock()
+
+	if !me.lp.used {
+		return
+	}
+	if !me.lp.asleep {
+		msg.add(hdrPowerState, fmt.Sprint(powerStateActive))
+		return
+	}
+	msg.add(hdrPowerState, fmt.Sprint(powerStateDeepSleepOnline))
+	msg.add(hdrSleepPeriod, fmt.Sprint(int(me.lp.period.Seconds())))
+}
@@ -0,0 +1,124 @@
+package ssdp
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// MsgKind represents the kind of an SSDP message
+type MsgKind int
+
+const (
+	// KindAlive is the kind of ssdp:alive notifications
+	KindAlive MsgKind = iota
+	// KindByeBye is the kind of ssdp:byebye notifications
+	KindByeBye
+	// KindResponse is the kind of responses to search requests
+	KindResponse
+)
+
+// header represents a header field of an SSDP message
+type header struct {
+	name  string
+	value string
+}
+
+// message represents an SSDP message
+type message struct {
+	kind    MsgKind
+	start   string
+	headers []header
+}
+
+// newNotification creates a new notification message of kind kind that is
+// sent to host
+func newNotification(kind MsgKind, host string) *message {
+	msg := &message{kind: kind, start: "NOTIFY * HTTP/1.1"}
+	msg.add("HOST", host)
+	return msg
+}
+
+// newResponse creates a new response message
+func newResponse() *message {
+	return &message{kind: KindResponse, start: "HTTP/1.1 200 OK"}
+}
+
+// add adds the header field name with the value value to the message
+func (me *message) add(name, value string) {
+	me.headers = append(me.headers, header{name, value})
+}
+
+// bytes returns the message text
+func (me *message) bytes() []byte {
+	buf := new(bytes.Buffer)
+	fmt.Fprintf(buf, "%s\r\n", me.start)
+	for _, h := range me.headers {
+		if h.value == "" {
+			fmt.Fprintf(buf, "%s:\r\n", h.name)
+			continue
+		}
+		fmt.Fprintf(buf, "%s: %s\r\n", h.name, h.value)
+	}
+	// add empty row at the end as required by the UPnP Device Architecture
+	// 2.0
+	fmt.Fprint(buf, "\r\n")
+	return buf.Bytes()
+}
+
+// Headers contains additional header fields for SSDP messages, such as vendor
+// or DLNA specific fields. It's safe for concurrent use
+type Headers struct {
+	fields map[MsgKind][]extHeader
+	mut    *sync.RWMutex
+}
+
+// extHeader represents an additional header field. If target is not empty, the
+// field is only added to messages with that NT (notifications) or ST (search
+// responses)
+type extHeader struct {
+	target string
+	header
+}
+
+// NewHeaders creates a new and empty set of additional header fields
+func NewHeaders() *Headers {
+	return &Headers{
+		fields: make(map[MsgKind][]extHeader),
+		mut:    new(sync.RWMutex),
+	}
+}
+
+// Add adds the header field name with the value value to all messages of kind
+// kind. If target is not empty, the field is only added to messages with that
+// NT (notifications) or ST (search responses)
+func (me *Headers) Add(kind MsgKind, target, name, value string) (err error) {
+	if name == "" || strings.ContainsAny(name, ": \r\n") || strings.ContainsAny(value, "\r\n") {
+		err = fmt.Errorf("invalid SSDP header field '%s: %s'", name, value)
+		return
+	}
+
+	me.mut.Lock()
+	me.fields[kind] = append(me.fields[kind], extHeader{target, header{name, value}})
+	me.mut.Unlock()
+
+	return
+}
+
+// apply adds the header fields that are relevant for msg. target is the NT or
+// ST of msg
+func (me *Headers) apply(msg *message, target string) {
+	if me == nil {
+		return
+	}
+
+	me.mut.RLock()
+	defer me.mut.RUnlock()
+
+	for _, h := range me.fields[msg.kind] {
+		if h.target == "" || h.target == target {
+			msg.add(h.name, h.value)
+		}
+	}
+}
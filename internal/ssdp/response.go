@@ -24,6 +24,10 @@ const (
 	stRoot = "upnp:rootdevice"
 )
 
+// readTimeout is the maximum time period that reading from the UDP connection
+// blocks
+const readTimeout = time.Second
+
 // SearchIndex maps keys like service and device types to the USNs that must be
 // sent as response to search requests
 type SearchIndex map[string](*([]string))
@@ -37,8 +41,20 @@ func isCompatible(a, b string) bool {
 	if !reTypes.MatchString(a) || !reTypes.MatchString(b) {
 		return false
 	}
-	n := strings.LastIndex(a, ":")
-	return n+1 < len(a) && n+1 < len(b) && a[:n] == b[:n] && a[n+1:] <= b[n+1:]
+	na, nb := strings.LastIndex(a, ":"), strings.LastIndex(b, ":")
+	if a[:na] != b[:nb] {
+		return false
+	}
+	// note: Versions must be compared as numbers, otherwise "10" < "9"
+	va, err := strconv.Atoi(a[na+1:])
+	if err != nil {
+		return false
+	}
+	vb, err := strconv.Atoi(b[nb+1:])
+	if err != nil {
+		return false
+	}
+	return va <= vb
 }
 
 // retrieve searches in me for a key that either matches target or if there is
@@ -74,10 +90,15 @@ func (me *Server) listenAndRespond() {
 			log.Tracef("response stopped on interface '%s'", me.inf.Name)
 			return
 		default:
-			// read data from UDP connection
+			// read data from UDP connection. The read deadline makes sure that
+			// the stop signal is checked regularly even if no messages arrive
 			msg := make([]byte, me.inf.MTU)
+			_ = me.conn.SetReadDeadline(time.Now().Add(readTimeout))
 			n, reqAddr, err := me.conn.ReadFromUDP(msg)
 			if err != nil {
+				if nErr, ok := err.(net.Error); ok && nErr.Timeout() {
+					continue
+				}
 				err = fmt.Errorf("search: error reading from UDP socket: %v", err)
 				log.Error(err)
 				continue
@@ -97,6 +118,18 @@ func (me *Server) listenAndRespond() {
 func (me *Server) respond(wg *sync.WaitGroup, msg []byte, reqAddr *net.UDPAddr) {
 	defer wg.Done()
 
+	// in unicast only mode, only search requests from peers are answered
+	if me.opts.UnicastOnly && !me.opts.isPeer(reqAddr.IP) {
+		log.Tracef("ignored search request from %s on interface %s since it's no peer", reqAddr.IP.String(), me.inf.Name)
+		return
+	}
+
+	// search requests from control points that are denied access are ignored
+	if me.opts.Accept != nil && !me.opts.Accept(reqAddr.IP) {
+		log.Tracef("ignored search request from %s on interface %s since access is denied", reqAddr.IP.String(), me.inf.Name)
+		return
+	}
+
 	// transform msg into HTTP request struct
 	r, err := parseIntoHTTPRequest(bufio.NewReader(bytes.NewReader(msg)))
 	if err != nil {
@@ -139,7 +172,7 @@ func (me *Server) sendResponse(st string, mx uint, reqAddr *net.UDPAddr, tcpPort
 			if i != 0 {
 				time.Sleep(time.Duration(mx) * time.Second / time.Duration(len(msgs)+1))
 			}
-			if err = network.SendUDP(me.conn, reqAddr, msgs[i].Bytes()); err != nil {
+			if err = network.SendUDP(me.conn, reqAddr, msgs[i].bytes()); err != nil {
 				err = errors.Wrap(err, "couldn't send SSDP search response")
 				log.Error(err)
 			}
@@ -167,7 +200,7 @@ func (me *Server) sendResponse(st string, mx uint, reqAddr *net.UDPAddr, tcpPort
 
 		// send messages
 		for _, msg := range msgs {
-			_ = network.SendTCP(conn, msg.Bytes())
+			_ = network.SendTCP(conn, msg.bytes())
 		}
 	}
 
@@ -175,26 +208,12 @@ func (me *Server) sendResponse(st string, mx uint, reqAddr *net.UDPAddr, tcpPort
 	return
 }
 
-// assembleResponseMsgs create the message texts for a response to a search request
-func (me *Server) assembleResponseMsgs(st string, tcpRequired bool) (msgs []*bytes.Buffer) {
+// assembleResponseMsgs create the messages for a response to a search request
+func (me *Server) assembleResponseMsgs(st string, tcpRequired bool) (msgs []*message) {
 	switch st {
 	case stAll:
 		for _, assID := range me.data.AssIDs {
-			msg := new(bytes.Buffer)
-			fmt.Fprint(msg, "HTTP/1.1 200 OK\r\n")
-			fmt.Fprintf(msg, "CACHE-CONTROL: max-age=%d\r\n", me.data.MaxAge)
-			fmt.Fprintf(msg, "DATE: %s\r\n", time.Now().Format(time.RFC1123))
-			fmt.Fprintf(msg, "EXT:\r\n")
-			fmt.Fprintf(msg, "LOCATION: %s\r\n", strings.Replace(me.data.Location, "{{ADDRESS}}", me.addr, -1))
-			fmt.Fprintf(msg, "SERVER: %s\r\n", me.data.Server)
-			fmt.Fprintf(msg, "ST: %s\r\n", stAll)
-			fmt.Fprintf(msg, "USN: %s\r\n", assID.USN)
-			fmt.Fprintf(msg, "BOOTID.UPNP.ORG: %d\r\n", me.bootID.Val())
-			fmt.Fprintf(msg, "CONFIG.UPNP.ORG: %d\r\n", me.configID.Val())
-			// add empty row at the end as required by the UPnP Device
-			// Architecture 2.0
-			fmt.Fprint(msg, "\r\n")
-			msgs = append(msgs, msg)
+			msgs = append(msgs, me.responseMsg(stAll, assID.USN))
 		}
 
 	case stRoot:
@@ -208,21 +227,7 @@ func (me *Server) assembleResponseMsgs(st string, tcpRequired bool) (msgs []*byt
 			log.Errorf("search response: for key '%s' more than one device is contained in search index", stRoot)
 			return
 		}
-		msg := new(bytes.Buffer)
-		fmt.Fprint(msg, "HTTP/1.1 200 OK\r\n")
-		fmt.Fprintf(msg, "CACHE-CONTROL: max-age=%d\r\n", me.data.MaxAge)
-		fmt.Fprintf(msg, "DATE: %s\r\n", time.Now().Format(time.RFC1123))
-		fmt.Fprintf(msg, "EXT:\r\n")
-		fmt.Fprintf(msg, "LOCATION: %s\r\n", strings.Replace(me.data.Location, "{{ADDRESS}}", me.addr, -1))
-		fmt.Fprintf(msg, "SERVER: %s\r\n", me.data.Server)
-		fmt.Fprintf(msg, "ST: %s\r\n", stRoot)
-		fmt.Fprintf(msg, "USN: %s\r\n", (*usns)[0])
-		fmt.Fprintf(msg, "BOOTID.UPNP.ORG: %d\r\n", me.bootID.Val())
-		fmt.Fprintf(msg, "CONFIG.UPNP.ORG: %d\r\n", me.configID.Val())
-		// add empty row at the end as required by the UPnP Device
-		// Architecture 2.0
-		fmt.Fprint(msg, "\r\n")
-		msgs = append(msgs, msg)
+		msgs = append(msgs, me.responseMsg(stRoot, (*usns)[0]))
 
 	default:
 		// get usns that must be sent
@@ -241,57 +246,38 @@ func (me *Server) assembleResponseMsgs(st string, tcpRequired bool) (msgs []*byt
 			// message shall be sent via TCP and there's only one USN, the same
 			// logic applies
 			for _, usn := range *usns {
-				msg := new(bytes.Buffer)
-				fmt.Fprint(msg, "HTTP/1.1 200 OK\r\n")
-				fmt.Fprintf(msg, "CACHE-CONTROL: max-age=%d\r\n", me.data.MaxAge)
-				fmt.Fprintf(msg, "DATE: %s\r\n", time.Now().Format(time.RFC1123))
-				fmt.Fprintf(msg, "EXT:\r\n")
-				fmt.Fprintf(msg, "LOCATION: %s\r\n", strings.Replace(me.data.Location, "{{ADDRESS}}", me.addr, -1))
-				fmt.Fprintf(msg, "SERVER: %s\r\n", me.data.Server)
-				fmt.Fprintf(msg, "ST: %s\r\n", st)
-				fmt.Fprintf(msg, "USN: %s\r\n", usn)
-				fmt.Fprintf(msg, "BOOTID.UPNP.ORG: %d\r\n", me.bootID.Val())
-				fmt.Fprintf(msg, "CONFIG.UPNP.ORG: %d\r\n", me.configID.Val())
-				// add empty row at the end as required by the UPnP Device
-				// Architecture 2.0
-				fmt.Fprint(msg, "\r\n")
-
-				msgs = append(msgs, msg)
+				msgs = append(msgs, me.responseMsg(st, usn))
 			}
 		} else {
 			// as specified in the UPnP Device Architecture 2.0, the search
 			// response must be sent via TCP - one message in total, the
 			// different USNs (if there are more than one) are sent as
 			// comma-separated list in the USN field
-			msg := new(bytes.Buffer)
-			fmt.Fprint(msg, "HTTP/1.1 200 OK\r\n")
-			fmt.Fprintf(msg, "CACHE-CONTROL: max-age=%d\r\n", me.data.MaxAge)
-			fmt.Fprintf(msg, "DATE: %s\r\n", time.Now().Format(time.RFC1123))
-			fmt.Fprintf(msg, "EXT:\r\n")
-			fmt.Fprintf(msg, "LOCATION: %s\r\n", strings.Replace(me.data.Location, "{{ADDRESS}}", me.addr, -1))
-			fmt.Fprintf(msg, "SERVER: %s\r\n", me.data.Server)
-			fmt.Fprintf(msg, "ST: %s\r\n", st)
-			for i := 0; i < len(*usns); i++ {
-				if i == 0 {
-					fmt.Fprintf(msg, "USN: %s", (*usns)[i])
-					continue
-				}
-				fmt.Fprintf(msg, ",%s", (*usns)[i])
-			}
-			fmt.Fprintf(msg, "\r\n")
-			fmt.Fprintf(msg, "BOOTID.UPNP.ORG: %d\r\n", me.bootID.Val())
-			fmt.Fprintf(msg, "CONFIG.UPNP.ORG: %d\r\n", me.configID.Val())
-			// add empty row at the end as required by the UPnP Device
-			// Architecture 2.0
-			fmt.Fprint(msg, "\r\n")
-
-			msgs = append(msgs, msg)
+			msgs = append(msgs, me.responseMsg(st, strings.Join(*usns, ",")))
 		}
 	}
 
 	return
 }
 
+// responseMsg creates a response message for a search request with search
+// target st and the USN usn
+func (me *Server) responseMsg(st, usn string) *message {
+	msg := newResponse()
+	msg.add("CACHE-CONTROL", fmt.Sprintf("max-age=%d", me.maxAge()))
+	msg.add("DATE", time.Now().Format(time.RFC1123))
+	msg.add("EXT", "")
+	msg.add("LOCATION", me.data.Location)
+	msg.add("SERVER", me.data.Server)
+	msg.add("ST", st)
+	msg.add("USN", usn)
+	msg.add("BOOTID.UPNP.ORG", fmt.Sprint(me.bootID.Val()))
+	msg.add("CONFIG.UPNP.ORG", fmt.Sprint(me.configID.Val()))
+	me.addPowerState(msg)
+	me.opts.Headers.apply(msg, st)
+	return msg
+}
+
 // analyzeHTTPRequest evaluates a search request and checks if it is relevant.
 // isRelevant is set accordingly. If the request is relevant, st, mx and
 // tcpPort are filled with the corresponding request values
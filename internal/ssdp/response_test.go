@@ -0,0 +1,29 @@
+package ssdp
+
+import "testing"
+
+func TestIsCompatible(t *testing.T) {
+	const cds = "urn:schemas-upnp-org:service:ContentDirectory:"
+
+	tests := []struct {
+		a, b string
+		ok   bool
+	}{
+		{cds + "1", cds + "1", true},
+		{cds + "1", cds + "4", true},
+		{cds + "4", cds + "1", false},
+		{cds + "9", cds + "10", true},
+		{cds + "10", cds + "9", false},
+		{cds + "x", cds + "1", false},
+		{cds + "1", cds + "x", false},
+		{cds + "1", "urn:schemas-upnp-org:service:ConnectionManager:1", false},
+		{cds + "1", "urn:schemas-upnp-org:device:ContentDirectory:1", false},
+		{"upnp:rootdevice", "upnp:rootdevice", false},
+	}
+
+	for _, test := range tests {
+		if ok := isCompatible(test.a, test.b); ok != test.ok {
+			t.Errorf("isCompatible(%s, %s) returned %t, expected %t", test.a, test.b, ok, test.ok)
+		}
+	}
+}
@@ -4,8 +4,6 @@ package ssdp
 import (
 	"fmt"
 	"net"
-	"strconv"
-	"strings"
 	"sync"
 
 	"github.com/pkg/errors"
@@ -18,6 +16,9 @@ var log *l.Entry
 
 const multicastAddrIPv4 = "239.255.255.250:1900"
 
+// Port is the SSDP port
+const Port = 1900
+
 var multicastUDPAddr *net.UDPAddr
 
 func init() {
@@ -38,7 +39,8 @@ type Server struct {
 	bootID   *types.BootID
 	configID *types.ConfigID
 	inf      net.Interface
-	addr     string
+	ip       net.IP
+	opts     Options
 	// data from device tree that is relevant for SSDP
 	data DiscoveryData
 	// index maps keys like device or service type to the corresponding device
@@ -52,12 +54,51 @@ type Server struct {
 	notifyStopped chan struct{}
 	// channel to receive confirmation about stop of response process
 	responseStopped chan struct{}
+	// low power status
+	lp lowPower
+}
+
+// Options contains optional settings of an SSDP server
+type Options struct {
+	// Peers contains the addresses of control points that receive alive and
+	// byebye messages via unicast
+	Peers []*net.UDPAddr
+	// UnicastOnly switches off multicast. Alive and byebye messages are only
+	// sent to the peers, and only search requests from the peers are answered
+	UnicastOnly bool
+	// Headers contains additional header fields for SSDP messages
+	Headers *Headers
+	// Accept decides whether search requests from a control point with a
+	// certain IP address are answered. If it's nil, all search requests are
+	// answered
+	Accept func(net.IP) bool
+}
+
+// isPeer returns true if ip is the IP address of one of the peers
+func (me Options) isPeer(ip net.IP) bool {
+	for _, peer := range me.Peers {
+		if peer.IP.Equal(ip) {
+			return true
+		}
+	}
+	return false
 }
 
-// New creates a new SSDP server
-func New(data DiscoveryData, index SearchIndex, bootID *types.BootID, configID *types.ConfigID, inf net.Interface, port int) (srv *Server, err error) {
+// New creates a new SSDP server for network interface inf with the IP address
+// ip. data.Location must contain the complete URL of the device description as
+// it is advertised on inf
+func New(data DiscoveryData, index SearchIndex, bootID *types.BootID, configID *types.ConfigID, inf net.Interface, ip net.IP, opts Options) (srv *Server, err error) {
 	log.Tracef("creating SSDP server for interface '%s'", inf.Name)
 
+	if data.Location == "" {
+		err = fmt.Errorf("no location for interface %s", inf.Name)
+		return
+	}
+	if opts.UnicastOnly && len(opts.Peers) == 0 {
+		err = fmt.Errorf("unicast only mode requires peers on interface %s", inf.Name)
+		return
+	}
+
 	srv = new(Server)
 
 	srv.data = data
@@ -65,28 +106,8 @@ func New(data DiscoveryData, index SearchIndex, bootID *types.BootID, configID *
 	srv.bootID = bootID
 	srv.configID = configID
 	srv.inf = inf
-
-	// determine ip4 address of interface
-	addrs, err := srv.inf.Addrs()
-	if err != nil {
-		err = errors.Wrapf(err, "cannot retrieve addresses of interface %s", srv.inf.Name)
-		return
-	}
-	for _, addr := range addrs {
-		if addr.(*net.IPNet).IP.To4() != nil {
-			a := strings.Split(addr.String(), ":")
-			a = strings.Split(a[0], "/")
-			srv.addr = a[0]
-			break
-		}
-	}
-	if srv.addr == "" {
-		err = fmt.Errorf("interface %s has no IP4 address", srv.inf.Name)
-		return
-	}
-	if port != 0 {
-		srv.addr += ":" + strconv.Itoa(port)
-	}
+	srv.ip = ip
+	srv.opts = opts
 
 	return
 }
@@ -96,7 +117,12 @@ func New(data DiscoveryData, index SearchIndex, bootID *types.BootID, configID *
 func (me *Server) Connect() (err error) {
 	log.Tracef("connecting SSDP server on interface '%s' ...", me.inf.Name)
 
-	if me.conn, err = network.UDPConn(me.inf, multicastUDPAddr); err != nil {
+	if me.opts.UnicastOnly {
+		me.conn, err = network.UnicastUDPConn(me.ip, Port)
+	} else {
+		me.conn, err = network.UDPConn(me.inf, multicastUDPAddr)
+	}
+	if err != nil {
 		err = errors.Wrapf(err, "cannot connect SSDP server on interface %s", me.inf.Name)
 		return
 	}
@@ -111,6 +137,14 @@ func (me *Server) Connect() (err error) {
 	return
 }
 
+// targets returns the addresses that alive and byebye messages are sent to
+func (me *Server) targets() (addrs []*net.UDPAddr) {
+	if !me.opts.UnicastOnly {
+		addrs = append(addrs, multicastUDPAddr)
+	}
+	return append(addrs, me.opts.Peers...)
+}
+
 // Disconnect disconnect the SSDP server (i.e. stops the notification and search
 // response processes)
 func (me *Server) Disconnect(wg *sync.WaitGroup) {
@@ -2,15 +2,22 @@ package yuppie
 
 import (
 	"context"
+	"fmt"
+	"net"
 	"net/http"
 	"runtime"
+	"sort"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/pkg/errors"
 	l "github.com/sirupsen/logrus"
 	"gitlab.com/go-utilities/system"
+	"gitlab.com/mipimipi/yuppie/audit"
 	"gitlab.com/mipimipi/yuppie/desc"
 	"gitlab.com/mipimipi/yuppie/internal/events"
+	"gitlab.com/mipimipi/yuppie/internal/network"
 	"gitlab.com/mipimipi/yuppie/internal/ssdp"
 	"gitlab.com/mipimipi/yuppie/internal/types"
 	"golang.org/x/text/cases"
@@ -40,13 +47,23 @@ type Server struct {
 	services            serviceMap
 	bootID              *types.BootID
 	configID            *types.ConfigID
+	infs                []network.Interface
+	bindIPs             []net.IP
+	access              accessPolicy
 	ssdps               []*ssdp.Server
+	ssdpHeaders         *ssdp.Headers
 	http                *http.Server
 	presentationHandler func(http.ResponseWriter, *http.Request)
 	httpHandlers        map[string](func(http.ResponseWriter, *http.Request))
-	soapHandlers        map[string](func(map[string]StateVar) (SOAPRespArgs, SOAPError))
+	soapHandlers        map[string]SOAPHandler
+	soapVerHandlers     map[string](map[int]SOAPHandler)
+	soapMiddlewares     []soapMiddleware
+	chunkWriters        sync.Pool
+	httpMiddlewares     []httpMiddleware
+	auditSinks          []audit.Sink
 	evt                 *events.Eventing
 	connected           bool
+	asleep              bool
 	// Locals contains variables that are persisted in the status.json of
 	// yuppie
 	Locals map[string]string
@@ -92,26 +109,41 @@ func New(cfg Config, rootDesc *desc.RootDevice, svcDescs desc.ServiceMap) (srv *
 
 	srv.Errs = make(chan error)
 	srv.cfg = cfg
+	srv.cfg.Limits = cfg.Limits.withDefaults()
+	if srv.access, err = cfg.Access.compile(srv.services); err != nil {
+		err = errors.Wrap(err, "cannot create UPnP server")
+		log.Fatal(err)
+		return
+	}
 	srv.bootID = types.NewBootID()
 	srv.configID = new(types.ConfigID)
 	srv.httpHandlers = make(map[string](func(http.ResponseWriter, *http.Request)))
-	srv.soapHandlers = make(map[string](func(map[string]StateVar) (SOAPRespArgs, SOAPError)))
+	srv.soapHandlers = make(map[string]SOAPHandler)
+	srv.soapVerHandlers = make(map[string](map[int]SOAPHandler))
+	srv.registerGetters()
 	srv.Locals = make(map[string]string)
+	srv.ssdpHeaders = ssdp.NewHeaders()
 	if err = srv.setStatus(); err != nil {
 		err = errors.Wrap(err, "cannot create UPnP server")
 		log.Fatal(err)
 		return
 	}
 
-	// srv.evt can only be create after srv.bootID is created. Otherwise a dump
-	// will occur if state variables are multicasted
-	srv.evt, err = events.NewEventing(cfg.Interfaces, srv.bootID)
-	if err != nil {
+	// determine the network interfaces that are used by the server
+	if err = srv.setInterfaces(); err != nil {
 		err = errors.Wrap(err, "cannot create UPnP server")
 		log.Fatal(err)
 		return
 	}
 
+	// srv.evt can only be create after srv.bootID is created. Otherwise a dump
+	// will occur if state variables are multicasted
+	var evtChunkSize int
+	if srv.cfg.ChunkedEvents {
+		evtChunkSize = srv.cfg.ChunkSize
+	}
+	srv.evt = events.NewEventing(srv.infs, srv.bootID, evtChunkSize)
+
 	// create SSDP servers (one for each network interface)
 	if err = srv.createSSDPServers(); err != nil {
 		err = errors.Wrap(err, "cannot create UPnP server")
@@ -144,6 +176,16 @@ func (me *Server) Connect(ctx context.Context) (err error) {
 
 	log.Trace("connecting ...")
 
+	// check that service descriptions and SOAP handlers fit together
+	if err = me.checkHandlers(); err != nil {
+		if me.cfg.StrictHandlers {
+			err = errors.Wrap(err, "cannot connect UPnP server")
+			log.Error(err)
+			return
+		}
+		err = nil
+	}
+
 	// shutdown presentation HTTP server
 	_ = me.http.Shutdown(ctx)
 
@@ -151,13 +193,11 @@ func (me *Server) Connect(ctx context.Context) (err error) {
 	me.createHTTPServer()
 
 	// start general HTTP server
-	go func() {
-		if err := me.http.ListenAndServe(); err != http.ErrServerClosed {
-			log.Fatalf("HTTP ListenAndServe: %v", err)
-			me.Errs <- err
-			return
-		}
-	}()
+	if err = me.startHTTPServer(); err != nil {
+		err = errors.Wrap(err, "cannot connect UPnP server")
+		log.Error(err)
+		return
+	}
 	log.Trace("general http server started")
 
 	// start SSDP servers
@@ -176,6 +216,7 @@ func (me *Server) Connect(ctx context.Context) (err error) {
 	me.evt.Run()
 
 	me.connected = true
+	me.asleep = false
 
 	log.Trace("connected")
 	return
@@ -197,13 +238,9 @@ func (me *Server) Disconnect(ctx context.Context) {
 	me.createPresentationServer()
 
 	// start presentation HTTP server
-	go func() {
-		if err := me.http.ListenAndServe(); err != http.ErrServerClosed {
-			log.Fatalf("HTTP ListenAndServe: %v", err)
-			me.Errs <- err
-			return
-		}
-	}()
+	if err := me.startHTTPServer(); err != nil {
+		log.Error(errors.Wrap(err, "cannot start presentation HTTP server"))
+	}
 	log.Trace("presentation HTTP server started")
 
 	me.connected = false
@@ -211,6 +248,75 @@ func (me *Server) Disconnect(ctx context.Context) {
 	log.Trace("disconnected")
 }
 
+// Sleep announces that the device goes to sleep for the time period period as
+// defined by the UPnP Low Power Architecture. The advertisements are kept valid
+// for the sleep period, regular alive messages are suspended and no events are
+// sent while the device is asleep. State variable changes are collected and
+// evented after wake-up
+func (me *Server) Sleep(period time.Duration) (err error) {
+	if !me.connected {
+		err = fmt.Errorf("cannot sleep since server is not connected")
+		log.Error(err)
+		return
+	}
+	if me.asleep {
+		log.Info("tried to sleep though server is already asleep")
+		return
+	}
+
+	log.Trace("going to sleep ...")
+
+	me.evt.Stop()
+
+	var wg sync.WaitGroup
+	for _, s := range me.ssdps {
+		wg.Add(1)
+		go func(s *ssdp.Server) {
+			defer wg.Done()
+			s.Sleep(period)
+		}(s)
+	}
+	wg.Wait()
+
+	me.asleep = true
+
+	log.Trace("asleep")
+	return
+}
+
+// Wake announces that the device woke up after a Sleep. Regular alive messages
+// and eventing are resumed
+func (me *Server) Wake() (err error) {
+	if !me.connected {
+		err = fmt.Errorf("cannot wake up since server is not connected")
+		log.Error(err)
+		return
+	}
+	if !me.asleep {
+		log.Info("tried to wake up though server is not asleep")
+		return
+	}
+
+	log.Trace("waking up ...")
+
+	var wg sync.WaitGroup
+	for _, s := range me.ssdps {
+		wg.Add(1)
+		go func(s *ssdp.Server) {
+			defer wg.Done()
+			s.Wake()
+		}(s)
+	}
+	wg.Wait()
+
+	me.evt.Run()
+
+	me.asleep = false
+
+	log.Trace("awake")
+	return
+}
+
 // Errors returns a receive-only channel for errors from the UPnP server
 func (me *Server) Errors() <-chan error {
 	return me.Errs
@@ -235,13 +341,9 @@ func (me *Server) Run(ctx context.Context, wg *sync.WaitGroup) {
 	me.createPresentationServer()
 
 	// start presentation HTTP server
-	go func() {
-		if err := me.http.ListenAndServe(); err != http.ErrServerClosed {
-			log.Fatalf("HTTP ListenAndServe: %v", err)
-			me.Errs <- err
-			return
-		}
-	}()
+	if err := me.startHTTPServer(); err != nil {
+		log.Error(errors.Wrap(err, "cannot start presentation HTTP server"))
+	}
 	log.Trace("presentation HTTP server started")
 
 	log.Trace("running ...")
@@ -312,11 +414,221 @@ func (me *Server) PresentationHandleFunc(handleFunc func(http.ResponseWriter, *h
 }
 
 // SOAPHandleFunc allows to register functions to handle UPnP SOAP requests.
-// Such handlers are defined per service ID / action combination
+// Such handlers are defined per service ID / action combination. Handlers that
+// need a context or metadata of the request can be registered with SOAPHandle
 func (me *Server) SOAPHandleFunc(svcID string, act string, handler func(map[string]StateVar) (SOAPRespArgs, SOAPError)) {
+	me.SOAPHandle(svcID, act,
+		func(_ context.Context, req *SOAPRequest) (SOAPRespArgs, SOAPError) {
+			return handler(req.Args)
+		},
+	)
+}
+
+// SOAPHandle allows to register handlers for UPnP SOAP requests that receive a
+// context that is tied to the HTTP request and the action request including
+// metadata such as remote address and HTTP header fields. Such handlers are
+// defined per service ID / action combination
+func (me *Server) SOAPHandle(svcID string, act string, handler SOAPHandler) {
 	me.soapHandlers[svcID+"#"+act] = handler
 }
 
+// SOAPHandleVersion registers a handler for action act of service svcID that is
+// only called if the control point requested version ver of the service.
+// Thus, a server that implements a newer version of a service can behave
+// differently for clients of older versions. For all other versions, the
+// handler registered via SOAPHandle or SOAPHandleFunc is called
+func (me *Server) SOAPHandleVersion(svcID string, act string, ver int, handler SOAPHandler) {
+	if _, exists := me.soapVerHandlers[svcID+"#"+act]; !exists {
+		me.soapVerHandlers[svcID+"#"+act] = make(map[int]SOAPHandler)
+	}
+	me.soapVerHandlers[svcID+"#"+act][ver] = handler
+}
+
+// ActionSince marks action act of service svcID as introduced with version ver
+// of the service. Calls of control points that requested an older version of
+// the service are rejected with error 401 (invalid action)
+func (me *Server) ActionSince(svcID, act string, ver int) (err error) {
+	svc, exists := me.services[svcID]
+	if !exists {
+		err = fmt.Errorf("cannot set version of action '%s': service '%s' does not exist", act, svcID)
+		log.Error(err)
+		return
+	}
+	if _, exists := svc.actSpecs[act]; !exists {
+		err = fmt.Errorf("cannot set version: service '%s' has no action '%s'", svcID, act)
+		log.Error(err)
+		return
+	}
+
+	svc.since[act] = ver
+	return
+}
+
+// soapHandler returns the handler for action act of service svcID for
+// requests of version ver of the service. Version-specific handlers take
+// precedence over general ones
+func (me *Server) soapHandler(svcID, act string, ver int) (handler SOAPHandler, exists bool) {
+	if handler, exists = me.soapVerHandlers[svcID+"#"+act][ver]; exists {
+		return
+	}
+	handler, exists = me.soapHandlers[svcID+"#"+act]
+	return
+}
+
+// registerGetters registers built-in SOAP handlers for all actions that simply
+// return the values of state variables (GetSystemUpdateID or GetTransportSettings, for
+// example). Applications can override these handlers by registering their own
+// ones via SOAPHandle or SOAPHandleFunc
+func (me *Server) registerGetters() {
+	if me.cfg.NoGetters {
+		return
+	}
+	for svcID, svc := range me.services {
+		for act := range svc.actSpecs {
+			if svc.isGetter(act) {
+				log.Tracef("register built-in handler for getter action '%s'", svcID+"#"+act)
+				me.soapHandlers[svcID+"#"+act] = svc.getter(act)
+			}
+		}
+	}
+}
+
+// checkHandlers compares the actions of the service descriptions with the
+// registered SOAP handlers. Actions without handler as well as handlers for
+// unknown services or actions are logged. If there's such an inconsistency, an
+// error is returned
+func (me *Server) checkHandlers() (err error) {
+	var unhandled, unknown []string
+
+	for svcID, svc := range me.services {
+		for act := range svc.actSpecs {
+			if _, exists := me.soapHandlers[svcID+"#"+act]; !exists {
+				unhandled = append(unhandled, svcID+"#"+act)
+			}
+		}
+	}
+	isUnknown := func(key string) bool {
+		s := strings.SplitN(key, "#", 2)
+		svc, exists := me.services[s[0]]
+		if !exists {
+			return true
+		}
+		_, exists = svc.actSpecs[s[1]]
+		return !exists
+	}
+	for key := range me.soapHandlers {
+		if isUnknown(key) {
+			unknown = append(unknown, key)
+		}
+	}
+	for key := range me.soapVerHandlers {
+		if _, exists := me.soapHandlers[key]; !exists && isUnknown(key) {
+			unknown = append(unknown, key)
+		}
+	}
+	sort.Strings(unhandled)
+	sort.Strings(unknown)
+
+	for _, act := range unhandled {
+		log.Warnf("action '%s' has no handler: calls will fail with error %d", act, UPnPErrorOptActionNotImplemented)
+	}
+	for _, act := range unknown {
+		log.Warnf("handler registered for '%s', but service or action does not exist", act)
+	}
+
+	if len(unhandled) > 0 || len(unknown) > 0 {
+		err = fmt.Errorf("%d action(s) without handler, %d handler(s) for unknown actions", len(unhandled), len(unknown))
+	}
+	return
+}
+
+// setInterfaces determines the network interfaces that are used by the server
+// based on the configuration
+func (me *Server) setInterfaces() (err error) {
+	if me.bindIPs, err = me.cfg.bindIPs(); err != nil {
+		err = errors.Wrap(err, "cannot determine network interfaces")
+		return
+	}
+	sel := network.Selector{
+		Include: me.cfg.Interfaces,
+		Exclude: me.cfg.ExcludedInterfaces,
+		BindIPs: me.bindIPs,
+	}
+	if err = sel.Validate(); err != nil {
+		err = errors.Wrap(err, "cannot determine network interfaces")
+		return
+	}
+	if me.infs, err = network.Interfaces(sel); err != nil {
+		err = errors.Wrap(err, "cannot determine network interfaces")
+		return
+	}
+	return
+}
+
+// RegisterSOAPError registers the description desc for the action-specific
+// error code code of service svcID. Only codes from the ranges 700-799
+// (defined by UPnP service specifications) and 800-899 (vendor-defined) can be
+// registered. If a SOAP handler of that service returns an error with that
+// code but without description, the registered description is sent
+func (me *Server) RegisterSOAPError(svcID string, code UPnPErrorCode, desc string) (err error) {
+	svc, exists := me.services[svcID]
+	if !exists {
+		err = fmt.Errorf("cannot register error %d: service '%s' does not exist", code, svcID)
+		log.Error(err)
+		return
+	}
+	if !code.isActionSpecific() {
+		err = fmt.Errorf("cannot register error %d: only codes from %d to %d can be registered", code, UPnPErrorStdActionMin, UPnPErrorVendorActionMax)
+		log.Error(err)
+		return
+	}
+
+	svc.errDescs[code] = desc
+	return
+}
+
+// SetActionTimeout sets the timeout for the SOAP handler of action act of
+// service svcID. It overrides the ActionTimeout from the configuration. A
+// negative timeout switches off the timeout for that action
+func (me *Server) SetActionTimeout(svcID, act string, timeout time.Duration) (err error) {
+	svc, exists := me.services[svcID]
+	if !exists {
+		err = fmt.Errorf("cannot set timeout for action '%s': service '%s' does not exist", act, svcID)
+		log.Error(err)
+		return
+	}
+	if _, exists := svc.actSpecs[act]; !exists {
+		err = fmt.Errorf("cannot set timeout: service '%s' has no action '%s'", svcID, act)
+		log.Error(err)
+		return
+	}
+
+	svc.timeouts[act] = timeout
+	return
+}
+
+// SerializeActions makes sure that the SOAP handlers of service svcID are
+// called one after the other. This is useful for services whose actions
+// change shared state (AVTransport, for example), since the handlers do not
+// have to do their own locking then. Waiting for the previous call counts
+// towards the timeout of an action. Note: A handler that does not return after
+// its timeout is exceeded blocks the service until it returns, i.e. all calls
+// in the meantime fail with error 501. Thus, handlers of serialized services
+// must respect the cancellation of their context
+func (me *Server) SerializeActions(svcID string) (err error) {
+	svc, exists := me.services[svcID]
+	if !exists {
+		err = fmt.Errorf("cannot serialize actions: service '%s' does not exist", svcID)
+		log.Error(err)
+		return
+	}
+
+	if svc.serial == nil {
+		svc.serial = make(chan struct{}, 1)
+	}
+	return
+}
+
 // sendEvents traverses through the device tree and sends an initial event for
 // each to-be-multicasted state variables
 func (me *Server) sendEvents() {
@@ -372,7 +684,11 @@ func (me *Server) setDescPaths() {
 func (me *Server) stop(ctx context.Context) {
 	log.Trace("stopping ...")
 
-	me.evt.Stop()
+	// eventing is already stopped if the server is asleep
+	if !me.asleep {
+		me.evt.Stop()
+	}
+	me.asleep = false
 
 	// stop SSDP servers
 	var wg sync.WaitGroup
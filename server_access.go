@@ -0,0 +1,157 @@
+package yuppie
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// accessRule is an access rule from the configuration with parsed addresses
+type accessRule struct {
+	svcID string
+	act   string
+	allow []*net.IPNet
+	deny  []*net.IPNet
+}
+
+// accessPolicy is the access policy from the configuration with parsed
+// addresses
+type accessPolicy []accessRule
+
+// compile checks the access policy against the services and parses the
+// addresses of its rules. Rules for unknown services or actions are logged
+// and an error is returned, since a misspelled Deny would leave access open
+func (me AccessPolicy) compile(services serviceMap) (policy accessPolicy, err error) {
+	var unknown []string
+	for _, rule := range me.Rules {
+		if rule.Service == "" && rule.Action != "" {
+			err = fmt.Errorf("access rule for action '%s' requires a service", rule.Action)
+			return
+		}
+		if rule.Service != "" && !services.hasAction(rule.Service, rule.Action) {
+			key := strings.TrimSuffix(rule.Service+"#"+rule.Action, "#")
+			unknown = append(unknown, key)
+			log.Warnf("access rule for '%s', but service or action does not exist", key)
+		}
+		r := accessRule{svcID: rule.Service, act: rule.Action}
+		if r.allow, err = parseIPNets(rule.Allow); err != nil {
+			return
+		}
+		if r.deny, err = parseIPNets(rule.Deny); err != nil {
+			return
+		}
+		policy = append(policy, r)
+	}
+	if len(unknown) > 0 {
+		err = fmt.Errorf("%d access rule(s) for unknown services or actions", len(unknown))
+	}
+	return
+}
+
+// parseIPNets parses IP addresses and networks in CIDR notation. IP addresses
+// are converted into networks that only contain that address
+func parseIPNets(addrs []string) (ipNets []*net.IPNet, err error) {
+	for _, addr := range addrs {
+		if _, ipNet, e := net.ParseCIDR(addr); e == nil {
+			ipNets = append(ipNets, ipNet)
+			continue
+		}
+		ip := net.ParseIP(addr)
+		if ip == nil {
+			err = fmt.Errorf("access rule address '%s' is neither an IP address nor a network", addr)
+			return
+		}
+		if ip.To4() != nil {
+			ip = ip.To4()
+		}
+		ipNets = append(ipNets, &net.IPNet{IP: ip, Mask: net.CIDRMask(8*len(ip), 8*len(ip))})
+	}
+	return
+}
+
+// containsIP returns true if one of the networks ipNets contains ip
+func containsIP(ipNets []*net.IPNet, ip net.IP) bool {
+	for _, ipNet := range ipNets {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// allowed returns true if the control point with IP address ip may access
+// action act of service svcID. If act is empty, only the rules for the
+// service and the global rules are taken into account, if svcID is empty
+// only the global rules
+func (me accessPolicy) allowed(ip net.IP, svcID, act string) bool {
+	if len(me) == 0 {
+		return true
+	}
+	if ip == nil {
+		return false
+	}
+
+	// scopes from the most specific to the global one
+	type scope struct{ svcID, act string }
+	var scopes []scope
+	if svcID != "" && act != "" {
+		scopes = append(scopes, scope{svcID, act})
+	}
+	if svcID != "" {
+		scopes = append(scopes, scope{svcID, ""})
+	}
+	scopes = append(scopes, scope{"", ""})
+
+	for _, sc := range scopes {
+		var restricted, allowed bool
+		for _, rule := range me {
+			if rule.svcID != sc.svcID || rule.act != sc.act {
+				continue
+			}
+			if containsIP(rule.deny, ip) {
+				return false
+			}
+			if len(rule.allow) > 0 {
+				restricted = true
+				allowed = allowed || containsIP(rule.allow, ip)
+			}
+		}
+		if allowed {
+			return true
+		}
+		if restricted {
+			return false
+		}
+	}
+
+	return true
+}
+
+// remoteIP returns the IP address of the sender of the HTTP request r or nil
+// if it cannot be determined
+func remoteIP(r *http.Request) net.IP {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return net.ParseIP(host)
+}
+
+// accessAllowed returns true if the sender of the HTTP request r may access
+// action act of service svcID (see accessPolicy.allowed). Denied requests are
+// logged
+func (me *Server) accessAllowed(r *http.Request, svcID, act string) bool {
+	if me.access.allowed(remoteIP(r), svcID, act) {
+		return true
+	}
+	switch {
+	case act != "":
+		log.Errorf("access of %s to action '%s' of service '%s' denied", r.RemoteAddr, act, svcID)
+	case svcID != "":
+		log.Errorf("access of %s to service '%s' denied", r.RemoteAddr, svcID)
+	default:
+		log.Errorf("access of %s to %s denied", r.RemoteAddr, r.URL.Path)
+	}
+	return false
+}
@@ -0,0 +1,78 @@
+package yuppie
+
+import (
+	"net"
+	"testing"
+)
+
+func TestAccessPolicyAllowed(t *testing.T) {
+	const cds = "ContentDirectory"
+
+	lan := net.IPv4(192, 168, 1, 10)
+	guest := net.IPv4(192, 168, 2, 10)
+	admin := net.IPv4(10, 0, 0, 1)
+
+	tests := []struct {
+		name  string
+		rules []AccessRule
+		ip    net.IP
+		svcID string
+		act   string
+		ok    bool
+	}{
+		{"no rules", nil, guest, cds, "Browse", true},
+		{"no rules, unknown address", nil, nil, cds, "Browse", true},
+		{"unknown address", []AccessRule{{Deny: []string{"10.0.0.0/8"}}}, nil, cds, "Browse", false},
+		{"global allow", []AccessRule{{Allow: []string{"192.168.1.0/24"}}}, lan, cds, "Browse", true},
+		{"global allow, other address", []AccessRule{{Allow: []string{"192.168.1.0/24"}}}, guest, cds, "Browse", false},
+		{"global deny", []AccessRule{{Deny: []string{"192.168.2.0/24"}}}, guest, cds, "Browse", false},
+		{"global deny, other address", []AccessRule{{Deny: []string{"192.168.2.0/24"}}}, lan, cds, "Browse", true},
+		{"deny beats allow in a scope", []AccessRule{{Allow: []string{"192.168.0.0/16"}, Deny: []string{"192.168.2.10"}}}, guest, cds, "Browse", false},
+		{"deny beats allow of other rule in a scope", []AccessRule{{Allow: []string{"192.168.0.0/16"}}, {Deny: []string{"192.168.2.10"}}}, guest, cds, "Browse", false},
+		{"service deny beats global allow", []AccessRule{{Allow: []string{"192.168.0.0/16"}}, {Service: cds, Deny: []string{"192.168.2.0/24"}}}, guest, cds, "Browse", false},
+		{"service allow beats global deny", []AccessRule{{Deny: []string{"10.0.0.0/8"}}, {Service: cds, Allow: []string{"10.0.0.1"}}}, admin, cds, "Browse", true},
+		{"action allow beats service deny", []AccessRule{{Service: cds, Deny: []string{"192.168.2.0/24"}}, {Service: cds, Action: "Browse", Allow: []string{"192.168.2.0/24"}}}, guest, cds, "Browse", true},
+		{"action allow beats global deny", []AccessRule{{Deny: []string{"192.168.2.0/24"}}, {Service: cds, Action: "Browse", Allow: []string{"192.168.2.0/24"}}}, guest, cds, "Browse", true},
+		{"action deny beats service allow", []AccessRule{{Service: cds, Allow: []string{"192.168.0.0/16"}}, {Service: cds, Action: "GetServiceResetToken", Deny: []string{"192.168.2.0/24"}}}, guest, cds, "GetServiceResetToken", false},
+		{"action rule does not apply to other actions", []AccessRule{{Service: cds, Action: "GetServiceResetToken", Allow: []string{"10.0.0.1"}}}, guest, cds, "Browse", true},
+		{"action allow restricts the action", []AccessRule{{Service: cds, Action: "GetServiceResetToken", Allow: []string{"10.0.0.1"}}}, guest, cds, "GetServiceResetToken", false},
+		{"service rule does not apply to other services", []AccessRule{{Service: cds, Deny: []string{"192.168.2.0/24"}}}, guest, "ConnectionManager", "GetProtocolInfo", true},
+		{"only global rules without service", []AccessRule{{Service: cds, Deny: []string{"192.168.2.0/24"}}}, guest, "", "", true},
+	}
+
+	srv := newTestServer(t)
+	for _, test := range tests {
+		policy, err := AccessPolicy{Rules: test.rules}.compile(srv.services)
+		if err != nil {
+			t.Errorf("%s: policy cannot be compiled: %v", test.name, err)
+			continue
+		}
+		if ok := policy.allowed(test.ip, test.svcID, test.act); ok != test.ok {
+			t.Errorf("%s: allowed returned %t, expected %t", test.name, ok, test.ok)
+		}
+	}
+}
+
+func TestAccessPolicyCompile(t *testing.T) {
+	tests := []struct {
+		name string
+		rule AccessRule
+		ok   bool
+	}{
+		{"global", AccessRule{Allow: []string{"192.168.1.0/24"}}, true},
+		{"service", AccessRule{Service: "ContentDirectory", Deny: []string{"192.168.1.10"}}, true},
+		{"action", AccessRule{Service: "ContentDirectory", Action: "Browse", Deny: []string{"192.168.1.10"}}, true},
+		{"QueryStateVariable", AccessRule{Service: "ContentDirectory", Action: "QueryStateVariable", Deny: []string{"192.168.1.10"}}, true},
+		{"unknown service", AccessRule{Service: "ContentDirectroy", Deny: []string{"192.168.1.10"}}, false},
+		{"unknown action", AccessRule{Service: "ContentDirectory", Action: "Browsx", Deny: []string{"192.168.1.10"}}, false},
+		{"action without service", AccessRule{Action: "Browse", Deny: []string{"192.168.1.10"}}, false},
+		{"invalid address", AccessRule{Deny: []string{"192.168.1"}}, false},
+	}
+
+	srv := newTestServer(t)
+	for _, test := range tests {
+		if _, err := (AccessPolicy{Rules: []AccessRule{test.rule}}).compile(srv.services); (err == nil) != test.ok {
+			t.Errorf("%s: compile returned error %v, expected success %t", test.name, err, test.ok)
+		}
+	}
+}
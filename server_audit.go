@@ -0,0 +1,151 @@
+package yuppie
+
+import (
+	"net/http"
+	"time"
+
+	"gitlab.com/mipimipi/yuppie/audit"
+)
+
+// redacted replaces the values of arguments that must not be written to the
+// audit log
+const redacted = "***"
+
+// auditWriter is a HTTP response writer that collects the data of the
+// response that is required for the audit log
+type auditWriter struct {
+	http.ResponseWriter
+	rec   audit.Record
+	start time.Time
+}
+
+// AddAuditSink adds sink to the audit log. If at least one sink is added,
+// an audit record is created for each call of a SOAP action and for each event
+// subscription request. The values of the arguments and header fields that
+// are contained in Config.AuditRedact are not written to the log
+func (me *Server) AddAuditSink(sink audit.Sink) {
+	me.auditSinks = append(me.auditSinks, sink)
+}
+
+// newAuditWriter wraps w to collect the audit data for the HTTP request r. If
+// no audit sinks are configured, nil is returned. All methods of auditWriter
+// that collect data can be called for nil
+func (me *Server) newAuditWriter(w http.ResponseWriter, r *http.Request) *auditWriter {
+	if len(me.auditSinks) == 0 {
+		return nil
+	}
+	return &auditWriter{
+		ResponseWriter: w,
+		rec: audit.Record{
+			Time:       time.Now(),
+			RemoteAddr: r.RemoteAddr,
+			UserAgent:  r.Header.Get("USER-AGENT"),
+			Status:     http.StatusOK,
+		},
+		start: time.Now(),
+	}
+}
+
+// action stores service ID and action
+func (me *auditWriter) action(svcID, act string) {
+	if me == nil {
+		return
+	}
+	me.rec.Service = svcID
+	me.rec.Action = act
+}
+
+// inputs stores the input arguments args
+func (me *auditWriter) inputs(args []soapArg) {
+	if me == nil {
+		return
+	}
+	me.rec.Inputs = soapArgsMap(args)
+}
+
+// outputs stores the output arguments args
+func (me *auditWriter) outputs(args []soapArg) {
+	if me == nil {
+		return
+	}
+	me.rec.Outputs = soapArgsMap(args)
+}
+
+// headers stores the header fields names of the request header h as inputs
+// and of the response header as outputs
+func (me *auditWriter) headers(h http.Header, in []string, out []string) {
+	if me == nil {
+		return
+	}
+	me.rec.Inputs = headerMap(h, in...)
+	me.rec.Outputs = headerMap(me.Header(), out...)
+}
+
+// WriteHeader stores the HTTP status and sends it
+func (me *auditWriter) WriteHeader(status int) {
+	me.rec.Status = status
+	me.ResponseWriter.WriteHeader(status)
+}
+
+// fault stores the SOAP error soapErr
+func (me *auditWriter) fault(soapErr SOAPError) {
+	if me == nil {
+		return
+	}
+	me.rec.FaultCode = int(soapErr.Code)
+	me.rec.FaultDesc = soapErr.Desc
+}
+
+// audit writes the audit record of aw to all sinks
+func (me *Server) audit(aw *auditWriter) {
+	if aw == nil {
+		return
+	}
+
+	aw.rec.Duration = time.Since(aw.start)
+	me.redact(aw.rec.Inputs)
+	me.redact(aw.rec.Outputs)
+
+	for _, sink := range me.auditSinks {
+		if err := sink.Write(aw.rec); err != nil {
+			log.Errorf("cannot write audit record: %v", err)
+		}
+	}
+}
+
+// redact replaces the values of the arguments that are contained in
+// Config.AuditRedact
+func (me *Server) redact(args map[string]string) {
+	for _, name := range me.cfg.AuditRedact {
+		if _, exists := args[name]; exists {
+			args[name] = redacted
+		}
+	}
+}
+
+// soapArgsMap converts SOAP arguments into a map
+func soapArgsMap(args []soapArg) map[string]string {
+	if len(args) == 0 {
+		return nil
+	}
+	m := make(map[string]string)
+	for _, arg := range args {
+		m[arg.Name] = arg.Value
+	}
+	return m
+}
+
+// headerMap returns the values of the header fields names of h as map. Empty
+// fields are not contained
+func headerMap(h http.Header, names ...string) map[string]string {
+	m := make(map[string]string)
+	for _, name := range names {
+		if v := h.Get(name); v != "" {
+			m[name] = v
+		}
+	}
+	if len(m) == 0 {
+		return nil
+	}
+	return m
+}
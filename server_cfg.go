@@ -1,10 +1,47 @@
 package yuppie
 
+import (
+	"fmt"
+	"net"
+	"time"
+)
+
+// default limits for incoming requests
+const (
+	// DefaultMaxBodySize is the default maximum size of a request body in
+	// bytes
+	DefaultMaxBodySize = 1 << 20
+	// DefaultMaxHeaderBytes is the default maximum size of the request header
+	// in bytes
+	DefaultMaxHeaderBytes = 64 << 10
+	// DefaultMaxXMLDepth is the default maximum nesting depth of elements in
+	// SOAP requests
+	DefaultMaxXMLDepth = 32
+	// DefaultMaxXMLTokens is the default maximum number of XML tokens in SOAP
+	// requests
+	DefaultMaxXMLTokens = 10000
+	// DefaultMaxArgs is the default maximum number of arguments of a SOAP
+	// action
+	DefaultMaxArgs = 64
+)
+
+// DefaultActionTimeout is the time period that a SOAP handler has to respond.
+// The UPnP Device Architecture 2.0 requires a device to respond to a control
+// request within 30 seconds
+const DefaultActionTimeout = 30 * time.Second
+
 // Config represents the configuration of the UPnP server
 type Config struct {
-	// Interfaces contain the names of the network interfaces to be used. If
-	// Interfaces is empty, all available interfaces will be used
+	// Interfaces contains patterns for the network interfaces to be used. A
+	// pattern can be an interface name or a name glob (e.g. "eth*"), a CIDR
+	// (e.g. "192.168.1.0/24") or an IP address. If Interfaces is empty, all
+	// available interfaces will be used - except virtual interfaces such as
+	// container bridges (docker*, veth*, br-*, virbr* etc.). Loopback
+	// interfaces are only used if they are contained explicitly
 	Interfaces []string
+	// ExcludedInterfaces contains patterns (same syntax as for Interfaces) for
+	// network interfaces that must not be used
+	ExcludedInterfaces []string
 	// Port is the port where the server listens
 	Port int
 	// MaxAge is the validity time period of the SSDP advertisement in seconds
@@ -18,8 +55,210 @@ type Config struct {
 	StatusFile string
 	// IconRootDir is the root directory for device icons. I.e. if the icon url
 	// in the device description is someDir/icon.png, for example, the icon
-	// must be located in IconRootDir/someDir/icon.png
+	// must be located in IconRootDir/someDir/icon.png. Only icons that are
+	// listed in the device description are served
 	IconRootDir string
+	// Advertised overrides scheme, host and port that are advertised in SSDP
+	// messages and that are used for absolute URLs. This is required if the
+	// server is not reachable via the address of the network interface and
+	// Port, e.g. if it runs behind a NAT, in a container with port mapping or
+	// behind a TCP proxy
+	Advertised AdvertisedAddr
+	// AdvertisedByInterface overrides Advertised for specific network
+	// interfaces. The keys are the interface names
+	AdvertisedByInterface map[string]AdvertisedAddr
+	// SSDPPeers contains the addresses of control points that receive SSDP
+	// alive and byebye messages via unicast (additionally to multicast). The
+	// addresses have the form host or host:port. If no port is given, the
+	// SSDP port 1900 is used
+	SSDPPeers []string
+	// SSDPUnicastOnly switches off SSDP multicast. That's useful in networks
+	// that drop multicast traffic. Alive and byebye messages are only sent to
+	// SSDPPeers, and only search requests from SSDPPeers are answered
+	SSDPUnicastOnly bool
+	// BindAddrs restricts SSDP, HTTP and eventing to the given IP addresses.
+	// Only network interfaces that have one of these addresses are used -
+	// loopback interfaces included. Interfaces and ExcludedInterfaces are
+	// applied to these interfaces as well
+	BindAddrs []string
+	// Loopback binds SSDP, HTTP and eventing to the loopback address
+	// 127.0.0.1 (additionally to BindAddrs). Thus, a server and a control
+	// point on the same host can discover each other, e.g. in tests or on
+	// machines without a real network interface
+	Loopback bool
+	// ActionTimeout is the maximum time period a SOAP handler has to respond.
+	// If it's exceeded, the action fails with error 501. If ActionTimeout is
+	// 0, DefaultActionTimeout is used. A negative value switches off the
+	// timeout. The timeout can be overridden per action with
+	// Server.SetActionTimeout. Note: The handler is not stopped when the
+	// timeout is exceeded. It should return once its context is done,
+	// especially if the actions of its service are serialized (see
+	// Server.SerializeActions)
+	ActionTimeout time.Duration
+	// LenientArgs makes the server accept SOAP requests of clients that do
+	// not send all input arguments. Missing arguments get the default value of
+	// their related state variable. Arguments without default value are still
+	// required. The arguments that are sent must be in the order of the
+	// service description nevertheless
+	LenientArgs bool
+	// StrictHandlers makes Connect fail if there are actions in the service
+	// descriptions without a registered SOAP handler or if handlers are
+	// registered for unknown services or actions. Otherwise, these
+	// inconsistencies are only logged
+	StrictHandlers bool
+	// NoGetters switches off the built-in handlers for actions that only
+	// return the values of state variables (e.g. GetSystemUpdateID). Handlers
+	// for these actions must be registered then
+	NoGetters bool
+	// NoQueryStateVariable switches off the support of the QueryStateVariable
+	// action of the UPnP Device Architecture 1.0. This action is deprecated,
+	// but still used by legacy control points to read evented state variables
+	NoQueryStateVariable bool
+	// AuditRedact contains the names of action arguments and header fields
+	// (e.g. "CALLBACK") whose values are not written to the audit log
+	AuditRedact []string
+	// ChunkSize switches on chunked transfer encoding for large SOAP responses
+	// if it's greater than 0: Responses whose body is larger than ChunkSize
+	// bytes are streamed to clients that use HTTP/1.1 with a buffer of that
+	// size. Smaller responses are always sent with a content length. Chunked
+	// requests are always accepted
+	ChunkSize int
+	// ChunkedEvents switches on chunked transfer encoding for event messages
+	// whose body is larger than ChunkSize bytes. It's a global opt-in: A
+	// SUBSCRIBE request doesn't tell if the subscriber accepts chunked
+	// messages, thus they are sent chunked to all subscribers. Only set it if
+	// all control points in the network support that
+	ChunkedEvents bool
+	// CheckHost switches on the validation of the Host header of requests for
+	// descriptions, control and eventing. This protects against DNS
+	// rebinding attacks: Only requests whose host is an IP address of the
+	// server, an advertised host or one of AllowedHosts are accepted. Other
+	// requests are rejected with HTTP status 403
+	CheckHost bool
+	// AllowedHosts contains additional host names (without port) that are
+	// accepted if CheckHost is set, e.g. the DNS name of the server
+	AllowedHosts []string
+	// Access restricts the access to the server by the IP address of the
+	// control point. Note: More specific rules override less specific ones,
+	// e.g. an action-level Allow grants access to that action even if the
+	// address is denied globally. Rules for unknown services or actions make
+	// New fail
+	Access AccessPolicy
+	// Limits restricts the size and complexity of incoming requests. Requests
+	// that exceed the limits are rejected
+	Limits Limits
+}
+
+// Limits contains limits for incoming requests. Attributes that are 0 are
+// set to the corresponding default values (DefaultMaxBodySize etc.)
+type Limits struct {
+	// MaxBodySize is the maximum size of a request body in bytes. Larger
+	// requests are rejected with HTTP status 413
+	MaxBodySize int64
+	// MaxHeaderBytes is the maximum size of the request header in bytes.
+	// Larger requests are rejected with HTTP status 431
+	MaxHeaderBytes int
+	// MaxXMLDepth is the maximum nesting depth of elements in SOAP requests
+	MaxXMLDepth int
+	// MaxXMLTokens is the maximum number of XML tokens (elements, character
+	// data etc.) in SOAP requests
+	MaxXMLTokens int
+	// MaxArgs is the maximum number of arguments of a SOAP action. Requests
+	// with more arguments are rejected with error 402
+	MaxArgs int
+}
+
+// withDefaults returns a copy of me where all attributes that are not set
+// are replaced by the default values
+func (me Limits) withDefaults() Limits {
+	if me.MaxBodySize == 0 {
+		me.MaxBodySize = DefaultMaxBodySize
+	}
+	if me.MaxHeaderBytes == 0 {
+		me.MaxHeaderBytes = DefaultMaxHeaderBytes
+	}
+	if me.MaxXMLDepth == 0 {
+		me.MaxXMLDepth = DefaultMaxXMLDepth
+	}
+	if me.MaxXMLTokens == 0 {
+		me.MaxXMLTokens = DefaultMaxXMLTokens
+	}
+	if me.MaxArgs == 0 {
+		me.MaxArgs = DefaultMaxArgs
+	}
+	return me
+}
+
+// AccessPolicy contains rules that determine which control points may access
+// the server. For a request, the rules of the most specific scope that decide
+// about the IP address of the control point are applied: First the rules for
+// the action, then the rules for the service, then the global rules. Within a
+// scope, Deny takes precedence over Allow. If a scope has rules with Allow
+// entries, addresses that are not allowed explicitly are denied. If no rule
+// decides, access is granted. Thus, an Allow for an action overrides a Deny
+// for its service or a global Deny
+type AccessPolicy struct {
+	// Rules contains the access rules
+	Rules []AccessRule
+	// SSDP applies the global rules to SSDP search requests as well: Search
+	// requests from control points that are denied are not answered
+	SSDP bool
+}
+
+// AccessRule allows or denies access for IP addresses or networks
+type AccessRule struct {
+	// Service is the ID of the service the rule applies to. If it's empty,
+	// the rule applies globally
+	Service string
+	// Action is the name of the action the rule applies to. If it's empty,
+	// the rule applies to the entire service. Action requires Service
+	Action string
+	// Allow contains IP addresses or networks in CIDR notation (e.g.
+	// 192.168.1.0/24) that are granted access
+	Allow []string
+	// Deny contains IP addresses or networks in CIDR notation that are denied
+	// access
+	Deny []string
+}
+
+// equal returns true if the access policies a and b are equal, otherwise false
+// is returned
+func (a AccessPolicy) equal(b AccessPolicy) bool {
+	if a.SSDP != b.SSDP || len(a.Rules) != len(b.Rules) {
+		return false
+	}
+	for i := range a.Rules {
+		if a.Rules[i].Service != b.Rules[i].Service || a.Rules[i].Action != b.Rules[i].Action || !equalStrings(a.Rules[i].Allow, b.Rules[i].Allow) || !equalStrings(a.Rules[i].Deny, b.Rules[i].Deny) {
+			return false
+		}
+	}
+	return true
+}
+
+// AdvertisedAddr represents the address of the server as it is seen from the
+// outside. Empty attributes are not overridden
+type AdvertisedAddr struct {
+	// Scheme is the URL scheme, e.g. "http" or "https"
+	Scheme string
+	// Host is the host name or the IP address
+	Host string
+	// Port is the port
+	Port int
+}
+
+// merge returns a copy of me where all attributes that are set in b are
+// overridden by the values from b
+func (me AdvertisedAddr) merge(b AdvertisedAddr) AdvertisedAddr {
+	if b.Scheme != "" {
+		me.Scheme = b.Scheme
+	}
+	if b.Host != "" {
+		me.Host = b.Host
+	}
+	if b.Port != 0 {
+		me.Port = b.Port
+	}
+	return me
 }
 
 // defaultCfg is the default configuration which is used if the server is created
@@ -35,14 +274,60 @@ var defaultCfg = Config{
 
 // equal returns true if two config structures are equal, otherwise false is returned
 func (a Config) equal(b Config) bool {
-	if len(a.Interfaces) != len(b.Interfaces) {
+	if !equalStrings(a.Interfaces, b.Interfaces) || !equalStrings(a.ExcludedInterfaces, b.ExcludedInterfaces) || !equalStrings(a.SSDPPeers, b.SSDPPeers) || !equalStrings(a.BindAddrs, b.BindAddrs) || !equalStrings(a.AuditRedact, b.AuditRedact) || !equalStrings(a.AllowedHosts, b.AllowedHosts) || !a.Access.equal(b.Access) {
 		return false
 	}
-	for i := 0; i < len(a.Interfaces); i++ {
-		if a.Interfaces[i] != b.Interfaces[i] {
+
+	if len(a.AdvertisedByInterface) != len(b.AdvertisedByInterface) {
+		return false
+	}
+	for name, adv := range a.AdvertisedByInterface {
+		if b.AdvertisedByInterface[name] != adv {
 			return false
 		}
 	}
 
-	return (a.Port == b.Port && a.MaxAge == b.MaxAge && a.ProductName == b.ProductName && a.ProductVersion == b.ProductVersion && a.StatusFile == b.StatusFile)
+	return (a.Port == b.Port && a.MaxAge == b.MaxAge && a.ProductName == b.ProductName && a.ProductVersion == b.ProductVersion && a.StatusFile == b.StatusFile && a.Advertised == b.Advertised && a.SSDPUnicastOnly == b.SSDPUnicastOnly && a.Loopback == b.Loopback && a.ActionTimeout == b.ActionTimeout && a.LenientArgs == b.LenientArgs && a.StrictHandlers == b.StrictHandlers && a.NoGetters == b.NoGetters && a.NoQueryStateVariable == b.NoQueryStateVariable && a.ChunkSize == b.ChunkSize && a.ChunkedEvents == b.ChunkedEvents && a.CheckHost == b.CheckHost && a.Limits == b.Limits)
+}
+
+// actionTimeout returns the timeout for SOAP handlers. 0 means that there's no
+// timeout
+func (me Config) actionTimeout() time.Duration {
+	switch {
+	case me.ActionTimeout == 0:
+		return DefaultActionTimeout
+	case me.ActionTimeout < 0:
+		return 0
+	}
+	return me.ActionTimeout
+}
+
+// bindIPs returns the IP addresses that the server shall be bound to
+func (me Config) bindIPs() (ips []net.IP, err error) {
+	for _, addr := range me.BindAddrs {
+		ip := net.ParseIP(addr)
+		if ip == nil || ip.To4() == nil {
+			err = fmt.Errorf("bind address '%s' is no IP4 address", addr)
+			return
+		}
+		ips = append(ips, ip.To4())
+	}
+	if me.Loopback {
+		ips = append(ips, net.IPv4(127, 0, 0, 1).To4())
+	}
+	return
+}
+
+// equalStrings returns true if the string arrays a and b are equal, otherwise
+// false is returned
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := 0; i < len(a); i++ {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
 }
@@ -1,37 +1,125 @@
 package yuppie
 
 import (
+	"context"
 	"fmt"
+	"net"
 	"net/http"
 	"net/url"
 	"path"
 	"path/filepath"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/pkg/errors"
 	fp "gitlab.com/go-utilities/filepath"
 	"gitlab.com/go-utilities/xml"
+	"gitlab.com/mipimipi/yuppie/desc"
 	"gitlab.com/mipimipi/yuppie/internal/events"
+	"gitlab.com/mipimipi/yuppie/internal/network"
 )
 
 const httpProtocol = "http"
 
+// baseURL returns the URL (i.e. scheme and host incl. port) under which the
+// server is advertised on the network interface with name infName and IP
+// address ip. The advertised addresses from the configuration are taken into
+// account
+func (me *Server) baseURL(infName string, ip net.IP) *url.URL {
+	adv := AdvertisedAddr{
+		Scheme: httpProtocol,
+		Host:   ip.String(),
+		Port:   me.cfg.Port,
+	}.merge(me.cfg.Advertised)
+	if infAdv, exists := me.cfg.AdvertisedByInterface[infName]; exists {
+		adv = adv.merge(infAdv)
+	}
+
+	u := &url.URL{Scheme: adv.Scheme, Host: adv.Host}
+	if adv.Port != 0 {
+		u.Host = net.JoinHostPort(adv.Host, strconv.Itoa(adv.Port))
+	}
+	return u
+}
+
+// BaseURL returns the URL (i.e. scheme and host incl. port) under which the
+// server is reachable for the sender of the HTTP request r. It can be used to
+// assemble absolute URLs, e.g. for resources. Advertised addresses from the
+// configuration are taken into account
+func (me *Server) BaseURL(r *http.Request) *url.URL {
+	addr, infName, ok := me.localAddr(r)
+	if !ok {
+		log.Errorf("cannot determine local address of request to %s", r.URL.String())
+		return &url.URL{Scheme: httpProtocol, Host: r.Host}
+	}
+	return me.baseURL(infName, addr.IP)
+}
+
+// localAddr determines the local address that the HTTP request r came in on
+// and the name of the corresponding network interface. The interface is
+// looked up in the interfaces used by the server first. Only if it's none of
+// them, the interfaces of the machine are determined
+func (me *Server) localAddr(r *http.Request) (addr *net.TCPAddr, infName string, ok bool) {
+	if addr, ok = r.Context().Value(http.LocalAddrContextKey).(*net.TCPAddr); !ok {
+		return
+	}
+
+	for _, inf := range me.infs {
+		if inf.IP.Equal(addr.IP) {
+			infName = inf.Name
+			return
+		}
+	}
+
+	inf, err := network.InterfaceByIP(addr.IP)
+	if err != nil {
+		log.Error(err)
+		return
+	}
+	infName = inf.Name
+	return
+}
+
+// newSOAPRequest creates a SOAP request for the action act of service svcID in
+// version ver from the HTTP request r. The arguments are set later on
+func (me *Server) newSOAPRequest(r *http.Request, svcID, act string, ver int) *SOAPRequest {
+	req := &SOAPRequest{
+		ServiceID:  svcID,
+		Action:     act,
+		Version:    ver,
+		RemoteAddr: r.RemoteAddr,
+		Host:       r.Host,
+		Header:     r.Header,
+	}
+	// note: The local address is determined only once since that requires
+	// a lookup of the network interface
+	if addr, infName, ok := me.localAddr(r); ok {
+		req.LocalAddr = addr
+		req.Interface = infName
+		req.BaseURL = me.baseURL(infName, addr.IP)
+	} else {
+		log.Errorf("cannot determine local address of request to %s", r.URL.String())
+		req.BaseURL = &url.URL{Scheme: httpProtocol, Host: r.Host}
+	}
+	return req
+}
+
 // createPresentationServer creates a new HTTP server me.http. The server only serves
 // the presentation URL of the root device
 func (me *Server) createPresentationServer() {
 	log.Trace("creating presentation server")
 
 	// create HTTP server
-	me.http = new(http.Server)
+	me.http = &http.Server{MaxHeaderBytes: me.cfg.Limits.MaxHeaderBytes}
 	if me.cfg.Port != 0 {
 		me.http.Addr = ":" + strconv.Itoa(me.cfg.Port)
 	}
 	mux := http.NewServeMux()
 	me.http.Handler = mux
 
-	mux.HandleFunc(me.Device.Desc.Device.PresentationURL, me.presentationHandler)
+	mux.Handle(me.Device.Desc.Device.PresentationURL, me.httpChain(HTTPUser, me.presentationHandler))
 
 	log.Tracef("presentation server created on %s", me.http.Addr)
 }
@@ -47,48 +135,135 @@ func (me *Server) createHTTPServer() {
 	log.Tracef("HTTP server created on %s", me.http.Addr)
 }
 
+// startHTTPServer starts the HTTP server me.http in the background. If bind
+// addresses are configured, the server listens on each of them, otherwise it
+// listens on all addresses. An error is returned if the server cannot listen
+// on any of the bind addresses
+func (me *Server) startHTTPServer() (err error) {
+	serve := func(listen func() error) {
+		if err := listen(); err != http.ErrServerClosed {
+			log.Fatalf("HTTP ListenAndServe: %v", err)
+			me.Errs <- err
+			return
+		}
+	}
+
+	if len(me.bindIPs) == 0 {
+		go serve(me.http.ListenAndServe)
+		return
+	}
+
+	// note: It's only an error if the server cannot listen on any of the bind
+	// addresses. Otherwise, it would not be reachable at all
+	var listening bool
+
+	port := "http"
+	if me.cfg.Port != 0 {
+		port = strconv.Itoa(me.cfg.Port)
+	}
+	for _, ip := range me.bindIPs {
+		addr := net.JoinHostPort(ip.String(), port)
+		l, err := net.Listen("tcp4", addr)
+		if err != nil {
+			err = errors.Wrapf(err, "cannot listen on %s", addr)
+			log.Error(err)
+			continue
+		}
+		go serve(func() error { return me.http.Serve(l) })
+		listening = true
+	}
+	if !listening {
+		err = fmt.Errorf("cannot listen on any of the bind addresses")
+	}
+	return
+}
+
 // setHttpHandleFuncs registers handler functions for device description,
 // service description requests and other URL patterns
 func (me *Server) setHTTPHandleFuncs() {
 	// device description
-	me.http.Handler.(*http.ServeMux).HandleFunc(deviceDescPath,
-		func(w http.ResponseWriter, r *http.Request) {
-			me.deviceDescHandler(w, r)
-		},
-	)
+	me.http.Handler.(*http.ServeMux).Handle(deviceDescPath, me.hostChecked(me.httpChain(HTTPBuiltin, me.deviceDescHandler)))
 
 	// device icons
-	me.http.Handler.(*http.ServeMux).HandleFunc(deviceIconPath,
-		func(w http.ResponseWriter, r *http.Request) {
-			me.deviceIconHandler(w, r)
-		},
-	)
+	me.http.Handler.(*http.ServeMux).Handle(deviceIconPath, me.httpChain(HTTPBuiltin, me.deviceIconHandler))
 
 	// service descriptions
-	me.http.Handler.(*http.ServeMux).HandleFunc(serviceDescPath,
-		func(w http.ResponseWriter, r *http.Request) {
-			me.serviceDescHandler(w, r)
-		},
-	)
+	me.http.Handler.(*http.ServeMux).Handle(serviceDescPath, me.hostChecked(me.httpChain(HTTPBuiltin, me.serviceDescHandler)))
 
 	// service control
-	me.http.Handler.(*http.ServeMux).HandleFunc(serviceControlPath,
-		func(w http.ResponseWriter, r *http.Request) {
-			me.serviceControlHandler(w, r)
-		},
-	)
+	me.http.Handler.(*http.ServeMux).Handle(serviceControlPath, me.hostChecked(me.httpChain(HTTPBuiltin, me.serviceControlHandler)))
 
 	// event subscription
-	me.http.Handler.(*http.ServeMux).HandleFunc(serviceEventSubPath,
-		func(w http.ResponseWriter, r *http.Request) {
-			me.serviceEventSubHandler(w, r)
-		},
-	)
+	me.http.Handler.(*http.ServeMux).Handle(serviceEventSubPath, me.hostChecked(me.httpChain(HTTPBuiltin, me.serviceEventSubHandler)))
 
 	// other patterns
 	for pattern, handleFunc := range me.httpHandlers {
-		me.http.Handler.(*http.ServeMux).HandleFunc(pattern, handleFunc)
+		me.http.Handler.(*http.ServeMux).Handle(pattern, me.httpChain(HTTPUser, handleFunc))
+	}
+}
+
+// hostChecked wraps handler with the validation of the Host header if that's
+// switched on in the configuration. Requests with a host that does not belong
+// to the server are rejected with HTTP status 403 to protect against DNS
+// rebinding
+func (me *Server) hostChecked(handler http.Handler) http.Handler {
+	if !me.cfg.CheckHost {
+		return handler
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !me.isOwnHost(r) {
+			log.Errorf("request for %s from %s rejected: host '%s' is not allowed", r.URL.Path, r.RemoteAddr, r.Host)
+			http.Error(w, fmt.Sprintf("host '%s' is not allowed", r.Host), http.StatusForbidden)
+			return
+		}
+		handler.ServeHTTP(w, r)
+	})
+}
+
+// isOwnHost returns true if the host of the HTTP request r is an IP address
+// of the server, an advertised host or one of the allowed hosts from the
+// configuration
+func (me *Server) isOwnHost(r *http.Request) bool {
+	host, _, err := net.SplitHostPort(r.Host)
+	if err != nil {
+		// note: Host header without port
+		host = r.Host
+	}
+	if host == "" {
+		return false
+	}
+
+	if ip := net.ParseIP(strings.Trim(host, "[]")); ip != nil {
+		if addr, ok := r.Context().Value(http.LocalAddrContextKey).(*net.TCPAddr); ok && addr.IP.Equal(ip) {
+			return true
+		}
+		for _, inf := range me.infs {
+			if inf.IP.Equal(ip) {
+				return true
+			}
+		}
+		for _, bindIP := range me.bindIPs {
+			if bindIP.Equal(ip) {
+				return true
+			}
+		}
+	}
+
+	if me.cfg.Advertised.Host != "" && strings.EqualFold(host, me.cfg.Advertised.Host) {
+		return true
 	}
+	for _, adv := range me.cfg.AdvertisedByInterface {
+		if adv.Host != "" && strings.EqualFold(host, adv.Host) {
+			return true
+		}
+	}
+	for _, allowed := range me.cfg.AllowedHosts {
+		if strings.EqualFold(host, allowed) {
+			return true
+		}
+	}
+
+	return false
 }
 
 // deviceDescHandler handles requests for the device description, i.e. requests
@@ -96,6 +271,11 @@ func (me *Server) setHTTPHandleFuncs() {
 func (me *Server) deviceDescHandler(w http.ResponseWriter, r *http.Request) {
 	log.Trace("description.xml requested")
 
+	if !me.accessAllowed(r, "", "") {
+		http.Error(w, "access denied", http.StatusForbidden)
+		return
+	}
+
 	// device description must contain current ConfigID as required by UPnP
 	// Device Architecture 2.0
 	me.Device.Desc.ConfigID = me.configID.Val()
@@ -127,19 +307,46 @@ func (me *Server) deviceDescHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 // deviceIconHandler handles requests for device icons, i.e. requests
-// for /device/*
+// for /device/*. Only icons that are listed in the device description are
+// served
 func (me *Server) deviceIconHandler(w http.ResponseWriter, r *http.Request) {
 	log.Tracef("icon requested: %s", r.URL.String())
 
-	iconPath, err := url.QueryUnescape(r.URL.String())
-	if err != nil {
-		err = errors.Wrapf(err, "cannot unescape URL: %s", r.URL.String())
-		log.Fatal(err)
-		http.Error(w, fmt.Sprintf("server error: cannot unescape URL: %s", r.URL.String()), http.StatusInternalServerError)
+	iconPath := path.Clean(r.URL.Path)
+	if !me.iconPaths()[iconPath] {
+		log.Errorf("requested icon %s is not contained in device description", iconPath)
+		http.NotFound(w, r)
+		return
 	}
 
 	// return icon
-	http.ServeFile(w, r, filepath.Join(me.cfg.IconRootDir, iconPath[len(deviceIconPath):]))
+	http.ServeFile(w, r, filepath.Join(me.cfg.IconRootDir, filepath.FromSlash(iconPath[len(deviceIconPath):])))
+}
+
+// iconPaths returns the URL paths of the icons of the root device and its
+// embedded devices. Relative icon URLs are resolved against the URL of the
+// device description
+func (me *Server) iconPaths() map[string]bool {
+	paths := make(map[string]bool)
+	base := &url.URL{Path: deviceDescPath}
+
+	var addIcons func(*desc.Device)
+	addIcons = func(dvc *desc.Device) {
+		for _, icon := range dvc.Icons {
+			u, err := url.Parse(icon.URL)
+			if err != nil {
+				log.Errorf("invalid icon URL '%s' in device description", icon.URL)
+				continue
+			}
+			paths[path.Clean(base.ResolveReference(u).Path)] = true
+		}
+		for i := range dvc.Devices {
+			addIcons(&dvc.Devices[i])
+		}
+	}
+	addIcons(&me.Device.Desc.Device)
+
+	return paths
 }
 
 // serviceDescHandler handles requests for service descriptions, i.e. requests
@@ -154,6 +361,11 @@ func (me *Server) serviceDescHandler(w http.ResponseWriter, r *http.Request) {
 
 	log.Tracef("service description for %s requested", id)
 
+	if !me.accessAllowed(r, id, "") {
+		http.Error(w, "access denied", http.StatusForbidden)
+		return
+	}
+
 	// get service
 	svc, ok := me.services[id]
 	if !ok {
@@ -192,57 +404,89 @@ func (me *Server) serviceDescHandler(w http.ResponseWriter, r *http.Request) {
 func (me *Server) serviceControlHandler(w http.ResponseWriter, r *http.Request) {
 	log.Trace("service control request received")
 
+	aw := me.newAuditWriter(w, r)
+	if aw != nil {
+		defer me.audit(aw)
+		w = aw
+	}
+
+	// control requests are sent via POST or M-POST
+	soapAct, status, err := soapActionHeader(r)
+	if err != nil {
+		log.Error(err)
+		if status == http.StatusMethodNotAllowed {
+			w.Header().Set("ALLOW", http.MethodPost+", "+methodMPost)
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+	// responses to M-POST requests must contain EXT as per RFC 2774
+	if r.Method == methodMPost {
+		w.Header().Set("EXT", "")
+	}
+
+	// QueryStateVariable is handled separately since it's no action of the
+	// service
+	if me.isQueryStateVariable(soapAct) {
+		me.queryStateVariableHandler(w, r, aw)
+		return
+	}
+
 	// get SOAP action
-	svcID, actName, err := me.parseSOAPAction(w, r)
+	svcID, actName, ver, err := me.parseSOAPAction(w, r, soapAct)
 	if err != nil {
 		err = errors.Wrap(err, "cannot parse SOAP action")
 		log.Error(err)
 		return
 	}
 
-	// verify that a handler for that action exists
-	handler, exists := me.soapHandlers[svcID+"#"+actName]
-	if !exists {
+	aw.action(svcID, actName)
+
+	// check if the control point may call the action
+	if !me.accessAllowed(r, svcID, actName) {
 		me.sendSOAPFault(w,
 			SOAPError{
-				Code: UPnPErrorOptActionNotImplemented,
-				Desc: fmt.Sprintf("no handler for action '%s'", svcID+"#"+actName),
+				Code: UPnPErrorActionNotAuthorized,
+				Desc: fmt.Sprintf("control point is not authorized to call action '%s'", actName),
 			},
 		)
-		log.Errorf("no handler for action '%s'", svcID+"#"+actName)
 		return
 	}
 
-	// get input arguments of action and verify that all of them fulfill the
-	// conditions wrt. ranges and allowed values
-	args, err := me.parseSOAPArguments(w, r, svcID, actName)
+	// read the SOAP document
+	action, err := me.readSOAPAction(w, r, actName)
 	if err != nil {
-		err = errors.Wrap(err, "cannot parse SOAP arguments")
+		err = errors.Wrap(err, "cannot read SOAP action")
 		log.Error(err)
 		return
 	}
+	aw.inputs(action.Args)
 
-	// invoke handler function and receive the response arguments
-	argsOut, soapErr := handler(args)
+	// execute action
+	respArgs, soapErr := me.runAction(r.Context(), me.newSOAPRequest(r, svcID, actName, ver), action.Args)
+	if errors.Is(r.Context().Err(), context.Canceled) {
+		// control point has gone away: there's nobody to respond to
+		return
+	}
 	if !soapErr.IsNil() {
 		me.sendSOAPFault(w, soapErr)
 		return
 	}
+	aw.outputs(respArgs)
 
-	// render response SOAP document
 	// note: Existence of service has already been check in parseSOAPAction()
 	svc := me.services[svcID]
-	respBody := soapActResp{
-		serviceType: string(svc.typ),
-		serviceVer:  string(svc.ver),
-		name:        actName,
-		args:        argsOut,
-	}.marshal()
+
+	// render response SOAP document. The namespace contains the service
+	// version that was requested by the control point
+	resp := soapActResp{
+		namespace: string(svc.typ) + ":" + strconv.Itoa(ver),
+		name:      actName,
+		args:      respArgs,
+	}
 
 	// send response
-	setHeader(w, me.ServerString(), len(respBody))
-	_, err = w.Write(respBody)
-	if err != nil {
+	if err = me.sendSOAPResponse(w, r, resp); err != nil {
 		log.Error(err)
 	}
 }
@@ -251,6 +495,27 @@ func (me *Server) serviceControlHandler(w http.ResponseWriter, r *http.Request)
 func (me *Server) serviceEventSubHandler(w http.ResponseWriter, r *http.Request) {
 	log.Tracef("event %s request received: ", r.Method)
 
+	_, svcID := path.Split(r.URL.Path)
+
+	aw := me.newAuditWriter(w, r)
+	if aw != nil {
+		act := r.Method
+		if r.Method == "SUBSCRIBE" && r.Header.Get("SID") != "" {
+			act = "RENEW"
+		}
+		aw.action(svcID, act)
+		defer func() {
+			aw.headers(r.Header, []string{"CALLBACK", "NT", "TIMEOUT", "SID"}, []string{"SID", "TIMEOUT"})
+			me.audit(aw)
+		}()
+		w = aw
+	}
+
+	if !me.accessAllowed(r, svcID, "") {
+		http.Error(w, "access denied", http.StatusForbidden)
+		return
+	}
+
 	switch r.Method {
 	case "SUBSCRIBE":
 		if r.Header.Get("SID") == "" {
@@ -292,6 +557,9 @@ func (me *Server) serviceEventSubHandler(w http.ResponseWriter, r *http.Request)
 			}
 
 			// assemble and send response
+			// note: The HTTP version of the SUBSCRIBE request doesn't tell if
+			// the subscriber accepts chunked event messages. Thus, they are
+			// only sent chunked if this is configured (see ChunkedEvents)
 			sid := me.evt.AddSub(dur, urls, svs)
 			w.Header().Set("DATE", time.Now().Format(time.RFC1123))
 			w.Header().Set("SERVER", me.ServerString())
@@ -0,0 +1,93 @@
+package yuppie
+
+import (
+	"net/http"
+)
+
+// SOAPMiddleware wraps a SOAP handler to add cross-cutting functionality such
+// as logging, authorization, metrics or tracing. The middleware has access to
+// service ID, action and arguments via the SOAP request that is passed to the
+// handler
+type SOAPMiddleware func(SOAPHandler) SOAPHandler
+
+// HTTPMiddleware wraps a HTTP handler to add cross-cutting functionality
+type HTTPMiddleware func(http.Handler) http.Handler
+
+// HTTPScope determines which HTTP routes a HTTP middleware is applied to
+type HTTPScope int
+
+// HTTP scopes
+const (
+	// HTTPBuiltin are the routes that are served by yuppie itself, i.e.
+	// device and service descriptions, icons, control and eventing
+	HTTPBuiltin HTTPScope = 1 << iota
+	// HTTPUser are the routes that were registered via HTTPHandleFunc and
+	// PresentationHandleFunc
+	HTTPUser
+	// HTTPAll are all routes
+	HTTPAll = HTTPBuiltin | HTTPUser
+)
+
+// soapMiddleware is a SOAP middleware together with its scope. An empty
+// service ID means that the middleware is applied to all services, an empty
+// action means that it's applied to all actions of the service
+type soapMiddleware struct {
+	svcID string
+	act   string
+	mw    SOAPMiddleware
+}
+
+// applies returns true if the middleware is applied to action act of service
+// svcID
+func (me soapMiddleware) applies(svcID, act string) bool {
+	return (me.svcID == "" || me.svcID == svcID) && (me.act == "" || me.act == act)
+}
+
+// httpMiddleware is a HTTP middleware together with its scope
+type httpMiddleware struct {
+	scope HTTPScope
+	mw    HTTPMiddleware
+}
+
+// UseSOAP registers the SOAP middleware mw. If svcID is empty, mw is applied to
+// the actions of all services. Otherwise, it is only applied to the actions of
+// that service. If act is not empty, mw is only applied to that action.
+// Middlewares are applied in the order of their registration, i.e. the
+// middleware that was registered first is the outermost one
+func (me *Server) UseSOAP(svcID, act string, mw SOAPMiddleware) {
+	log.Tracef("use SOAP middleware for service '%s', action '%s'", svcID, act)
+
+	me.soapMiddlewares = append(me.soapMiddlewares, soapMiddleware{svcID, act, mw})
+}
+
+// UseHTTP registers the HTTP middleware mw for the routes of the given scope.
+// Middlewares are applied in the order of their registration, i.e. the
+// middleware that was registered first is the outermost one. They must be
+// registered before the server is connected
+func (me *Server) UseHTTP(scope HTTPScope, mw HTTPMiddleware) {
+	log.Tracef("use HTTP middleware for scope %d", scope)
+
+	me.httpMiddlewares = append(me.httpMiddlewares, httpMiddleware{scope, mw})
+}
+
+// soapChain wraps handler with the SOAP middlewares that apply to action act
+// of service svcID
+func (me *Server) soapChain(svcID, act string, handler SOAPHandler) SOAPHandler {
+	for i := len(me.soapMiddlewares) - 1; i >= 0; i-- {
+		if me.soapMiddlewares[i].applies(svcID, act) {
+			handler = me.soapMiddlewares[i].mw(handler)
+		}
+	}
+	return handler
+}
+
+// httpChain wraps handleFunc with the HTTP middlewares that apply to scope
+func (me *Server) httpChain(scope HTTPScope, handleFunc func(http.ResponseWriter, *http.Request)) http.Handler {
+	var handler http.Handler = http.HandlerFunc(handleFunc)
+	for i := len(me.httpMiddlewares) - 1; i >= 0; i-- {
+		if me.httpMiddlewares[i].scope&scope != 0 {
+			handler = me.httpMiddlewares[i].mw(handler)
+		}
+	}
+	return handler
+}
@@ -1,12 +1,14 @@
 package yuppie
 
 import (
-	"encoding/xml"
+	"context"
 	"fmt"
-	"io"
+	"net"
 	"net/http"
 	"path"
 	"regexp"
+	"runtime/debug"
+	"sort"
 	"strings"
 
 	"github.com/pkg/errors"
@@ -14,13 +16,59 @@ import (
 
 var reSOAPAction = regexp.MustCompile(`"urn:schemas-upnp-org:service:.+:.+#.+"`)
 
-// parseSOAPAction evaluates a HTTP request to check if it's a call of a SOAP
-// action of a. If that's the case, the corresponding service id and action
-// name is extracted
-func (me *Server) parseSOAPAction(w http.ResponseWriter, r *http.Request) (id, act string, err error) {
+// methodMPost is the HTTP method for mandatory POST requests (see RFC 2774)
+const methodMPost = "M-POST"
+
+// namespace and name of the QueryStateVariable action of the UPnP Device
+// Architecture 1.0
+const (
+	queryStateVarNS  = "urn:schemas-upnp-org:control-1-0"
+	queryStateVarAct = "QueryStateVariable"
+)
+
+// soapActionHeader returns the value of the SOAPACTION header field of the
+// HTTP request r. POST requests contain the field as is. M-POST requests (see
+// UPnP Device Architecture 1.0) must contain a MAN header field that declares
+// the SOAP envelope namespace together with a prefix (e.g.
+// MAN: "http://schemas.xmlsoap.org/soap/envelope/"; ns=01). The SOAPACTION
+// field is prefixed with it then (01-SOAPACTION). If the request is not
+// acceptable, the HTTP status to be sent is returned
+func soapActionHeader(r *http.Request) (soapAct string, status int, err error) {
+	switch r.Method {
+	case http.MethodPost:
+		return r.Header.Get("SOAPACTION"), http.StatusOK, nil
+
+	case methodMPost:
+		for _, decl := range strings.Split(r.Header.Get("MAN"), ",") {
+			s := strings.Split(decl, ";")
+			if strings.Trim(strings.TrimSpace(s[0]), `"`) != soapEnvNS {
+				continue
+			}
+			for _, param := range s[1:] {
+				if kv := strings.SplitN(strings.TrimSpace(param), "=", 2); len(kv) == 2 && kv[0] == "ns" {
+					return r.Header.Get(kv[1] + "-SOAPACTION"), http.StatusOK, nil
+				}
+			}
+			// note: Without a namespace prefix, the field is not prefixed
+			return r.Header.Get("SOAPACTION"), http.StatusOK, nil
+		}
+		// as per RFC 2774, 510 is sent if the mandatory extension is not
+		// declared
+		return "", http.StatusNotExtended, fmt.Errorf("M-POST request without SOAP envelope in MAN header: %s", r.Header.Get("MAN"))
+	}
+
+	return "", http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed for control requests", r.Method)
+}
+
+// parseSOAPAction evaluates a HTTP request with SOAPACTION header field
+// soapAct to check if it's a call of a SOAP action of a. If that's the case,
+// the corresponding service id, action name and requested service version is
+// extracted
+func (me *Server) parseSOAPAction(w http.ResponseWriter, r *http.Request, soapAct string) (id, act string, ver int, err error) {
 	// extract service id and check if that it's valid
 	_, id = path.Split(r.URL.Path)
-	if _, exists := me.services[id]; !exists {
+	svc, exists := me.services[id]
+	if !exists {
 		err = fmt.Errorf("service '%s' does not exist", id)
 		log.Error(err)
 		http.Error(w, err.Error(), http.StatusBadRequest)
@@ -28,7 +76,6 @@ func (me *Server) parseSOAPAction(w http.ResponseWriter, r *http.Request) (id, a
 	}
 
 	// check if requested SOAPACTION is valid
-	soapAct := r.Header.Get("SOAPACTION")
 	if !reSOAPAction.MatchString(soapAct) {
 		me.sendSOAPFault(w,
 			SOAPError{
@@ -38,12 +85,11 @@ func (me *Server) parseSOAPAction(w http.ResponseWriter, r *http.Request) (id, a
 		)
 		err = fmt.Errorf("invalid SOAPACTION: %s", soapAct)
 		log.Error(err)
-		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 	s := strings.Split(soapAct[1:len(soapAct)-1], "#")
 	// - does requested action exist?
-	if _, exists := me.services[id].actSpecs[s[1]]; !exists {
+	if _, exists := svc.actSpecs[s[1]]; !exists {
 		me.sendSOAPFault(w,
 			SOAPError{
 				Code: UPnPErrorInvalidAction,
@@ -59,7 +105,7 @@ func (me *Server) parseSOAPAction(w http.ResponseWriter, r *http.Request) (id, a
 	s = strings.Split(s[0], ":")
 	svcTyp := strings.Join(s[0:4], ":")
 	svcVer := s[4]
-	if me.services[id].typ != serviceType(svcTyp) {
+	if svc.typ != serviceType(svcTyp) {
 		me.sendSOAPFault(w,
 			SOAPError{
 				Code: UPnPErrorInvalidAction,
@@ -70,126 +116,542 @@ func (me *Server) parseSOAPAction(w http.ResponseWriter, r *http.Request) (id, a
 		log.Error(err)
 		return
 	}
-	if me.services[id].ver < serviceVersion(svcVer) {
+	// note: Versions must be compared as numbers, otherwise "10" < "9"
+	implVer, err := svc.ver.number()
+	if err != nil {
 		me.sendSOAPFault(w,
 			SOAPError{
 				Code: UPnPErrorInvalidAction,
-				Desc: fmt.Sprintf("requested service version too high: %s", svcVer),
+				Desc: fmt.Sprintf("invalid version of service %s: %s", id, svc.ver),
 			},
 		)
-		err = fmt.Errorf("requested service version too high: %s", svcVer)
+		err = errors.Wrapf(err, "invalid version of service '%s'", id)
 		log.Error(err)
 		return
 	}
-
-	return
-}
-
-// parseSOAPArguments evaluates an HTTP request which is supposed to be a SOAP
-// action call and extract the arguments
-func (me *Server) parseSOAPArguments(w http.ResponseWriter, r *http.Request, svcID, act string) (args map[string]StateVar, err error) {
-	// get request body
-	reqBody, err := io.ReadAll(r.Body)
-
-	if err != nil {
+	if ver, err = serviceVersion(svcVer).number(); err != nil || ver > implVer {
 		me.sendSOAPFault(w,
 			SOAPError{
-				Code: UPnPErrorHumanRequired,
-				Desc: fmt.Sprintf("message body for action '%s' cannot be read", act),
+				Code: UPnPErrorInvalidAction,
+				Desc: fmt.Sprintf("requested service version not supported: %s", svcVer),
 			},
 		)
-		err = errors.Wrap(err, "request body cannot be read")
+		err = fmt.Errorf("requested service version not supported: %s", svcVer)
 		log.Error(err)
 		return
 	}
-
-	var env soapEnv
-	if err = xml.Unmarshal(reqBody, &env); err != nil {
+	// - does the action exist in the requested version?
+	if ver < svc.since[act] {
 		me.sendSOAPFault(w,
 			SOAPError{
-				Code: UPnPErrorHumanRequired,
-				Desc: fmt.Sprintf("message body for action '%s' cannot be unmarshalled", act),
+				Code: UPnPErrorInvalidAction,
+				Desc: fmt.Sprintf("action %s does not exist in version %d", act, ver),
 			},
 		)
-		err = errors.Wrap(err, "request body cannot be unmarshalled")
+		err = fmt.Errorf("action '%s' does not exist in version %d", act, ver)
 		log.Error(err)
 		return
 	}
 
-	var action soapAct
-	if err = xml.Unmarshal(env.Body.Content, &action); err != nil {
-		me.sendSOAPFault(w,
-			SOAPError{
-				Code: UPnPErrorHumanRequired,
-				Desc: fmt.Sprintf("message body for action '%s' cannot be unmarshalled", act),
-			},
+	return
+}
+
+// readSOAPAction reads the body of the HTTP request r which is supposed to be a
+// call of the SOAP action act and decodes it. Requests that exceed the
+// configured limits are rejected
+func (me *Server) readSOAPAction(w http.ResponseWriter, r *http.Request, act string) (action soapAct, err error) {
+	body := http.MaxBytesReader(w, r.Body, me.cfg.Limits.MaxBodySize)
+	if action, err = decodeSOAPAction(body, me.cfg.Limits); err != nil {
+		var (
+			errBody *http.MaxBytesError
+			errLim  errLimit
+			errArgs errTooManyArgs
 		)
-		err = errors.Wrapf(err, "message body for action '%s' cannot be unmarshalled", act)
+		switch {
+		case errors.As(err, &errBody):
+			err = fmt.Errorf("message body for action '%s' is larger than %d bytes", act, me.cfg.Limits.MaxBodySize)
+			http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+		case errors.As(err, &errLim):
+			err = errors.Wrapf(err, "message body for action '%s' is too complex", act)
+			http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+		case errors.As(err, &errArgs):
+			me.sendSOAPFault(w,
+				SOAPError{
+					Code: UPnPErrorInvalidArgs,
+					Desc: fmt.Sprintf("too many arguments for action '%s'", act),
+				},
+			)
+			err = errors.Wrapf(err, "too many arguments for action '%s'", act)
+		default:
+			me.sendSOAPFault(w,
+				SOAPError{
+					Code: UPnPErrorHumanRequired,
+					Desc: fmt.Sprintf("message body for action '%s' cannot be unmarshalled", act),
+				},
+			)
+			err = errors.Wrapf(err, "message body for action '%s' cannot be unmarshalled", act)
+		}
 		log.Error(err)
 		return
 	}
+	return
+}
+
+// soapArgs checks the input arguments in of action act of service svcID
+// against the service description and converts them into state variables
+func (me *Server) soapArgs(svcID, act string, in []soapArg) (args map[string]StateVar, soapErr SOAPError) {
+	// note: Here, we can assume that the maps me.services and
+	// me.services[..].actSpecs contain the required elements
+	svc := me.services[svcID]
+
+	// the input arguments must be sent exactly once and in the order of the
+	// service description as required by the UPnP Device Architecture 2.0
+	pos := make(map[string]int)
+	for i, spec := range svc.inSpecs[act] {
+		pos[spec.name] = i
+	}
+	last := -1
 
 	args = make(map[string]StateVar)
-	for _, arg := range action.Args {
-		// get argument spec. Note: Here, we can assume that the maps
-		// me.services and me.services[..].actSpecs contain the required
-		// elements
-		sv, exists := me.services[svcID].actSpecs[act][arg.Name]
+	for _, arg := range in {
+		// get argument spec
+		sv, exists := svc.actSpecs[act][arg.Name]
 		if !exists {
-			me.sendSOAPFault(w,
-				SOAPError{
-					Code: UPnPErrorInvalidArgs,
-					Desc: fmt.Sprintf("no specification for argument '%s' of action '%s' found", arg.Name, act),
-				},
-			)
-			err = fmt.Errorf("no specification for argument '%s' of action '%s' found", arg.Name, act)
-			log.Error(err)
+			soapErr = SOAPError{
+				Code: UPnPErrorInvalidArgs,
+				Desc: fmt.Sprintf("no specification for argument '%s' of action '%s' found", arg.Name, act),
+			}
+			log.Error(soapErr.Desc)
 			return
 		}
 
+		// check that argument is not duplicate and in the right order
+		if _, exists := args[arg.Name]; exists {
+			soapErr = SOAPError{
+				Code: UPnPErrorInvalidArgs,
+				Desc: fmt.Sprintf("argument '%s' of action '%s' is duplicate", arg.Name, act),
+			}
+			log.Error(soapErr.Desc)
+			return
+		}
+		if pos[arg.Name] < last {
+			soapErr = SOAPError{
+				Code: UPnPErrorInvalidArgs,
+				Desc: fmt.Sprintf("argument '%s' of action '%s' is out of order", arg.Name, act),
+			}
+			log.Error(soapErr.Desc)
+			return
+		}
+		last = pos[arg.Name]
+
 		// check if argument is valid (i.e. is in the specified range -
 		// provided that's a numeric value - or is in the allowed value list -
 		// provided it's a string)
 		if isValid, errCode := sv.IsValid(arg.Value); !isValid {
-			me.sendSOAPFault(w,
-				SOAPError{
-					Code: errCode,
-					Desc: fmt.Sprintf("arg %s is not valid: %s", arg.Name, arg.Value),
-				},
-			)
-			err = fmt.Errorf("arg %s is not valid: %s", arg.Name, arg.Value)
-			log.Error(err)
+			soapErr = SOAPError{
+				Code: errCode,
+				Desc: fmt.Sprintf("arg %s is not valid: %s", arg.Name, arg.Value),
+			}
+			log.Error(soapErr.Desc)
 			return
 		}
 
 		// create variable for argument and add it to result
+		var err error
 		if args[arg.Name], err = newStateVar(sv.Type(), arg.Value); err != nil {
-			me.sendSOAPFault(w,
-				SOAPError{
-					Code: UPnPErrorInvalidArgs,
-					Desc: err.Error(),
-				},
-			)
+			soapErr = SOAPError{
+				Code: UPnPErrorInvalidArgs,
+				Desc: err.Error(),
+			}
+			log.Error(err)
+			return
 		}
+	}
 
+	// check that no argument is missing. In lenient mode, missing arguments
+	// get the default value of their state variable
+	for _, spec := range svc.inSpecs[act] {
+		if _, exists := args[spec.name]; exists {
+			continue
+		}
+		if !me.cfg.LenientArgs || spec.sv.def == nil {
+			soapErr = SOAPError{
+				Code: UPnPErrorInvalidArgs,
+				Desc: fmt.Sprintf("argument '%s' of action '%s' is missing", spec.name, act),
+			}
+			log.Error(soapErr.Desc)
+			return
+		}
+		var err error
+		if args[spec.name], err = newStateVar(spec.sv.Type(), spec.sv.def.String()); err != nil {
+			soapErr = SOAPError{
+				Code: UPnPErrorActionFailed,
+				Desc: fmt.Sprintf("default value for argument '%s' of action '%s' cannot be set", spec.name, act),
+			}
+			err = errors.Wrapf(err, "cannot set default value for argument '%s' of action '%s'", spec.name, act)
+			log.Error(err)
+			return
+		}
 	}
 
 	return
 }
 
-// sendSOAPFault sends a SOAP fault message
-func (me *Server) sendSOAPFault(w http.ResponseWriter, soapErr SOAPError) {
-	fault := soapErr.marshal()
+// runAction executes the SOAP action that is represented by req with the input
+// arguments in: The handler is determined, the arguments are checked and
+// converted, the handler is called (wrapped by the applicable middlewares) and
+// its response is checked against the service description. The output
+// arguments are returned in the order of the service description
+func (me *Server) runAction(ctx context.Context, req *SOAPRequest, in []soapArg) (resp []soapArg, soapErr SOAPError) {
+	// verify that a handler for that action exists
+	handler, exists := me.soapHandler(req.ServiceID, req.Action, req.Version)
+	if !exists {
+		soapErr = SOAPError{
+			Code: UPnPErrorOptActionNotImplemented,
+			Desc: fmt.Sprintf("no handler for action '%s'", req.ServiceID+"#"+req.Action),
+		}
+		log.Error(soapErr.Desc)
+		return
+	}
+
+	// get input arguments of action and verify that all of them fulfill the
+	// conditions wrt. ranges and allowed values
+	if req.Args, soapErr = me.soapArgs(req.ServiceID, req.Action, in); !soapErr.IsNil() {
+		return
+	}
+
+	// invoke handler function wrapped by the applicable middlewares and
+	// receive the response arguments
+	svc := me.services[req.ServiceID]
+	argsOut, soapErr := me.callSOAPHandler(ctx, me.soapChain(req.ServiceID, req.Action, handler), req)
+	if !soapErr.IsNil() {
+		soapErr = svc.completeError(soapErr)
+		return
+	}
+
+	// check the output arguments against the service description and bring
+	// them into the required order
+	resp, err := svc.respArgs(req.Action, argsOut)
+	if err != nil {
+		err = errors.Wrapf(err, "handler for action '%s' returned invalid response", req.ServiceID+"#"+req.Action)
+		log.Error(err)
+		soapErr = SOAPError{
+			Code: UPnPErrorActionFailed,
+			Desc: fmt.Sprintf("action '%s' returned an invalid response", req.Action),
+		}
+	}
+	return
+}
+
+// Invoke calls action act of service svcID with the input arguments args
+// in-process, i.e. without network involved. It runs the same steps as a call
+// via HTTP: the arguments are checked against the service description and
+// converted, the handler is called (wrapped by the applicable middlewares) and
+// its response is checked. The output arguments are returned as state
+// variables of the types of the service description. Since there's no HTTP
+// request, the metadata of the SOAP request is synthetic: Local and remote
+// address are loopback addresses, BaseURL is the URL the server is advertised
+// with on its first network interface. Invoke is meant for testing SOAP
+// handlers
+func (me *Server) Invoke(ctx context.Context, svcID, act string, args map[string]string) (map[string]StateVar, SOAPError) {
+	svc, exists := me.services[svcID]
+	if !exists {
+		return nil, SOAPError{
+			Code: UPnPErrorInvalidAction,
+			Desc: fmt.Sprintf("service '%s' does not exist", svcID),
+		}
+	}
+	if _, exists := svc.actSpecs[act]; !exists {
+		return nil, SOAPError{
+			Code: UPnPErrorInvalidAction,
+			Desc: fmt.Sprintf("unknown action: %s", act),
+		}
+	}
+
+	// bring arguments into the order of the service description. Unknown
+	// arguments are appended to be rejected
+	var in []soapArg
+	for _, spec := range svc.inSpecs[act] {
+		if value, exists := args[spec.name]; exists {
+			in = append(in, soapArg{Name: spec.name, Value: value})
+		}
+	}
+	var unknown []string
+	for name := range args {
+		if _, exists := svc.actSpecs[act][name]; !exists {
+			unknown = append(unknown, name)
+		}
+	}
+	sort.Strings(unknown)
+	for _, name := range unknown {
+		in = append(in, soapArg{Name: name, Value: args[name]})
+	}
+
+	ver, _ := svc.ver.number()
+	resp, soapErr := me.runAction(ctx, me.newInvokeRequest(svcID, act, ver), in)
+	if !soapErr.IsNil() {
+		return nil, soapErr
+	}
+
+	// convert output arguments into state variables. Note: runAction has
+	// checked them against the service description already and has brought
+	// them into the order of the output specs
+	argsOut := make(map[string]StateVar)
+	for i, spec := range svc.outSpecs[act] {
+		arg := resp[i]
+		var err error
+		if argsOut[arg.Name], err = newStateVar(spec.sv.Type(), arg.Value); err != nil {
+			err = errors.Wrapf(err, "cannot convert output argument '%s' of action '%s'", arg.Name, svcID+"#"+act)
+			log.Error(err)
+			return nil, SOAPError{
+				Code: UPnPErrorActionFailed,
+				Desc: fmt.Sprintf("action '%s' returned an invalid response", act),
+			}
+		}
+	}
+	return argsOut, SOAPError{}
+}
+
+// newInvokeRequest creates a SOAP request for the in-process call of action
+// act of service svcID in version ver (see Invoke)
+func (me *Server) newInvokeRequest(svcID, act string, ver int) *SOAPRequest {
+	loopback := net.IPv4(127, 0, 0, 1)
+
+	req := &SOAPRequest{
+		ServiceID:  svcID,
+		Action:     act,
+		Version:    ver,
+		RemoteAddr: net.JoinHostPort(loopback.String(), "0"),
+		LocalAddr:  &net.TCPAddr{IP: loopback, Port: me.cfg.Port},
+		Header:     make(http.Header),
+	}
+	if len(me.infs) > 0 {
+		req.Interface = me.infs[0].Name
+		req.BaseURL = me.baseURL(me.infs[0].Name, me.infs[0].IP)
+	} else {
+		req.BaseURL = me.baseURL("", loopback)
+	}
+	req.Host = req.BaseURL.Host
+	return req
+}
+
+// isQueryStateVariable returns true if the SOAPACTION header field soapAct
+// represents a call of the QueryStateVariable action of the UPnP Device
+// Architecture 1.0 and if the support of that action is not switched off
+func (me *Server) isQueryStateVariable(soapAct string) bool {
+	return !me.cfg.NoQueryStateVariable && soapAct == `"`+queryStateVarNS+"#"+queryStateVarAct+`"`
+}
+
+// queryStateVariableHandler handles calls of the QueryStateVariable action.
+// Like other actions, the call is checked against the access policy, passed
+// through the applicable SOAP middlewares and recorded in the audit log aw
+func (me *Server) queryStateVariableHandler(w http.ResponseWriter, r *http.Request, aw *auditWriter) {
+	_, svcID := path.Split(r.URL.Path)
+	if _, exists := me.services[svcID]; !exists {
+		err := fmt.Errorf("service '%s' does not exist", svcID)
+		log.Error(err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 
-	setHeader(w, me.ServerString(), len(fault))
-	w.WriteHeader(http.StatusInternalServerError)
-	n, err := w.Write(fault)
+	aw.action(svcID, queryStateVarAct)
+
+	// check if the control point may call the action
+	if !me.accessAllowed(r, svcID, queryStateVarAct) {
+		me.sendSOAPFault(w,
+			SOAPError{
+				Code: UPnPErrorActionNotAuthorized,
+				Desc: fmt.Sprintf("control point is not authorized to call '%s'", queryStateVarAct),
+			},
+		)
+		return
+	}
+
+	action, err := me.readSOAPAction(w, r, queryStateVarAct)
 	if err != nil {
-		err = errors.Wrap(err, "SOAP fault message cannot be written to HTTP response")
-		log.Fatal(err)
+		err = errors.Wrap(err, "cannot parse QueryStateVariable request")
+		log.Error(err)
+		return
+	}
+	aw.inputs(action.Args)
+	if len(action.Args) != 1 || action.Args[0].Name != "varName" {
+		me.sendSOAPFault(w,
+			SOAPError{
+				Code: UPnPErrorInvalidArgs,
+				Desc: "QueryStateVariable requires exactly one argument varName",
+			},
+		)
+		log.Error("QueryStateVariable requires exactly one argument varName")
+		return
+	}
+
+	req := me.newSOAPRequest(r, svcID, queryStateVarAct, 1)
+	varName, err := newStateVar("string", action.Args[0].Value)
+	if err != nil {
+		me.sendSOAPFault(w,
+			SOAPError{
+				Code: UPnPErrorArgValInvalid,
+				Desc: "argument varName has an invalid value",
+			},
+		)
+		log.Error(errors.Wrap(err, "invalid argument varName of QueryStateVariable"))
+		return
+	}
+	req.Args = map[string]StateVar{"varName": varName}
+
+	// execute the action wrapped by the applicable middlewares
+	respArgs, soapErr := me.soapChain(svcID, queryStateVarAct, me.queryStateVariable)(r.Context(), req)
+	if !soapErr.IsNil() {
+		me.sendSOAPFault(w, soapErr)
+		return
+	}
+	value, exists := respArgs["return"]
+	if !exists {
+		me.sendSOAPFault(w,
+			SOAPError{
+				Code: UPnPErrorActionFailed,
+				Desc: fmt.Sprintf("action '%s' returned an invalid response", queryStateVarAct),
+			},
+		)
+		log.Errorf("handler for '%s' returned no argument 'return'", queryStateVarAct)
+		return
+	}
+	resp := soapActResp{
+		namespace: queryStateVarNS,
+		name:      queryStateVarAct,
+		args:      []soapArg{{Name: "return", Value: value}},
+	}
+	aw.outputs(resp.args)
+
+	if err = me.sendSOAPDocument(w, http.StatusOK, resp.encode); err != nil {
+		log.Error(err)
+	}
+}
+
+// queryStateVariable is the SOAP handler of the QueryStateVariable action. It
+// returns the current value of an evented state variable of the service.
+// Variables that only exist to assign a type to arguments (A_ARG_TYPE_...) are
+// never returned
+func (me *Server) queryStateVariable(_ context.Context, req *SOAPRequest) (SOAPRespArgs, SOAPError) {
+	name := req.Args["varName"].String()
+
+	sv, exists := me.services[req.ServiceID].stateVars[name]
+	if !exists || isArgType(name) || !(sv.toBeEvented || sv.toBeMulticasted) {
+		log.Errorf("state variable '%s' of service '%s' cannot be queried", name, req.ServiceID)
+		return nil, SOAPError{
+			Code: UPnPErrorInvalidVar,
+			Desc: fmt.Sprintf("state variable '%s' cannot be queried", name),
+		}
 	}
-	if n < len(fault) {
-		err = fmt.Errorf("incomplete write of SOAP fault: %d/%d bytes", n, len(fault))
-		log.Fatal(err)
+
+	sv.StateVar.Lock()
+	value := sv.String()
+	sv.StateVar.Unlock()
+
+	return SOAPRespArgs{"return": value}, SOAPError{}
+}
+
+// soapResult is the result of a SOAP handler call
+type soapResult struct {
+	args    SOAPRespArgs
+	soapErr SOAPError
+}
+
+// callSOAPHandler calls handler for the SOAP request req. If the handler does
+// not respond in time, the call is aborted with error 501. This is also the
+// case if the handler panics or if ctx is canceled. If the actions of the
+// service are serialized, the call waits until the previous calls are
+// finished. Waiting is aborted as well if the timeout is exceeded
+func (me *Server) callSOAPHandler(ctx context.Context, handler SOAPHandler, req *SOAPRequest) (SOAPRespArgs, SOAPError) {
+	svc := me.services[req.ServiceID]
+
+	timeout := me.cfg.actionTimeout()
+	if t, exists := svc.timeouts[req.Action]; exists {
+		timeout = t
+		if timeout < 0 {
+			timeout = 0
+		}
+	}
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	// note: Waiting for the previous call respects ctx. Otherwise, a handler
+	// that does not return would block the calls of the service forever
+	if svc.serial != nil {
+		select {
+		case svc.serial <- struct{}{}:
+		case <-ctx.Done():
+			return nil, abortedCall(ctx, req)
+		}
+	}
+
+	// note: The channel is buffered to make sure that the goroutine terminates
+	// if the handler responds after the timeout
+	res := make(chan soapResult, 1)
+	go func() {
+		defer func() {
+			if svc.serial != nil {
+				<-svc.serial
+			}
+		}()
+		defer func() {
+			if r := recover(); r != nil {
+				log.Errorf("handler for action '%s' panicked: %v\n%s", req.ServiceID+"#"+req.Action, r, debug.Stack())
+				res <- soapResult{
+					soapErr: SOAPError{
+						Code: UPnPErrorActionFailed,
+						Desc: fmt.Sprintf("action '%s' failed", req.Action),
+					},
+				}
+			}
+		}()
+
+		args, soapErr := handler(ctx, req)
+		res <- soapResult{args, soapErr}
+	}()
+
+	select {
+	case r := <-res:
+		return r.args, r.soapErr
+	case <-ctx.Done():
+		return nil, abortedCall(ctx, req)
+	}
+}
+
+// abortedCall returns the error for the call of a SOAP handler for req that
+// was aborted since ctx is done
+func abortedCall(ctx context.Context, req *SOAPRequest) SOAPError {
+	// note: If the request was canceled (e.g. since the control point closed
+	// the connection), that's no timeout
+	if errors.Is(ctx.Err(), context.Canceled) {
+		log.Tracef("call of action '%s' was canceled", req.ServiceID+"#"+req.Action)
+		return SOAPError{
+			Code: UPnPErrorActionFailed,
+			Desc: fmt.Sprintf("action '%s' was canceled", req.Action),
+		}
+	}
+	log.Errorf("handler for action '%s' did not respond in time: %v", req.ServiceID+"#"+req.Action, ctx.Err())
+	return SOAPError{
+		Code: UPnPErrorActionFailed,
+		Desc: fmt.Sprintf("action '%s' timed out", req.Action),
+	}
+}
+
+// sendSOAPFault sends a SOAP fault message. If soapErr has no description, the
+// default description of its code is used. As the UPnP Device Architecture
+// requires, fault messages are always sent with HTTP status 500
+func (me *Server) sendSOAPFault(w http.ResponseWriter, soapErr SOAPError) {
+	if soapErr.Desc == "" {
+		soapErr.Desc = soapErr.Code.String()
+	}
+	if aw, ok := w.(*auditWriter); ok {
+		aw.fault(soapErr)
+	}
+	if err := me.sendSOAPDocument(w, http.StatusInternalServerError, soapErr.encode); err != nil {
+		err = errors.Wrap(err, "SOAP fault message cannot be sent")
+		log.Error(err)
 	}
 }
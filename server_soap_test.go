@@ -0,0 +1,112 @@
+package yuppie
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"gitlab.com/mipimipi/yuppie/desc"
+)
+
+// newTestServer creates a server for the example device that is bound to the
+// loopback address
+func newTestServer(t *testing.T) *Server {
+	t.Helper()
+
+	root, err := desc.LoadRootDevice("example/device.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	svc, err := desc.LoadService("example/contentdirectory.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv, err := New(
+		Config{
+			Port:       8008,
+			MaxAge:     1800,
+			StatusFile: filepath.Join(t.TempDir(), "status.json"),
+			Loopback:   true,
+		},
+		root,
+		desc.ServiceMap{"ContentDirectory": svc},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return srv
+}
+
+func TestInvokeRequestMetadata(t *testing.T) {
+	srv := newTestServer(t)
+
+	srv.SOAPHandle("ContentDirectory", "Browse",
+		func(_ context.Context, req *SOAPRequest) (SOAPRespArgs, SOAPError) {
+			// note: Dereferences the metadata like handlers for HTTP requests do
+			musicURL := *req.BaseURL
+			musicURL.Path = "/music/"
+			return SOAPRespArgs{
+				"Result":         musicURL.String() + " " + req.LocalAddr.String() + " " + req.RemoteAddr,
+				"NumberReturned": "1",
+				"TotalMatches":   "2",
+				"UpdateID":       "3",
+			}, SOAPError{}
+		},
+	)
+
+	out, soapErr := srv.Invoke(context.Background(), "ContentDirectory", "Browse",
+		map[string]string{
+			"ObjectID":       "0",
+			"BrowseFlag":     "BrowseMetadata",
+			"Filter":         "*",
+			"StartingIndex":  "0",
+			"RequestedCount": "0",
+			"SortCriteria":   "",
+		},
+	)
+	if !soapErr.IsNil() {
+		t.Fatalf("Invoke failed: %d %s", soapErr.Code, soapErr.Desc)
+	}
+
+	if want := "http://127.0.0.1:8008/music/ 127.0.0.1:8008 127.0.0.1:0"; out["Result"].String() != want {
+		t.Errorf("Result is '%s', expected '%s'", out["Result"].String(), want)
+	}
+	if n, ok := out["TotalMatches"].Get().(uint32); !ok || n != 2 {
+		t.Errorf("TotalMatches is %v (%T), expected uint32 2", out["TotalMatches"].Get(), out["TotalMatches"].Get())
+	}
+}
+
+func TestSOAPActionHeader(t *testing.T) {
+	const act = `"urn:schemas-upnp-org:service:ContentDirectory:1#Browse"`
+
+	tests := []struct {
+		name    string
+		method  string
+		header  map[string]string
+		soapAct string
+		status  int
+	}{
+		{"POST", http.MethodPost, map[string]string{"SOAPACTION": act}, act, http.StatusOK},
+		{"M-POST with ns", methodMPost, map[string]string{"MAN": `"http://schemas.xmlsoap.org/soap/envelope/"; ns=01`, "01-SOAPACTION": act}, act, http.StatusOK},
+		{"M-POST ignores unprefixed field", methodMPost, map[string]string{"MAN": `"http://schemas.xmlsoap.org/soap/envelope/"; ns=01`, "SOAPACTION": act}, "", http.StatusOK},
+		{"M-POST without ns", methodMPost, map[string]string{"MAN": `"http://schemas.xmlsoap.org/soap/envelope/"`, "SOAPACTION": act}, act, http.StatusOK},
+		{"M-POST with several declarations", methodMPost, map[string]string{"MAN": `"http://example.com/ext"; ns=02, "http://schemas.xmlsoap.org/soap/envelope/"; ns=03`, "03-SOAPACTION": act}, act, http.StatusOK},
+		{"M-POST without SOAP envelope", methodMPost, map[string]string{"MAN": `"http://example.com/ext"; ns=01`, "01-SOAPACTION": act}, "", http.StatusNotExtended},
+		{"M-POST without MAN", methodMPost, map[string]string{"SOAPACTION": act}, "", http.StatusNotExtended},
+		{"GET", http.MethodGet, map[string]string{"SOAPACTION": act}, "", http.StatusMethodNotAllowed},
+	}
+
+	for _, test := range tests {
+		r := httptest.NewRequest(test.method, "/services/control/ContentDirectory", nil)
+		for k, v := range test.header {
+			r.Header.Set(k, v)
+		}
+		soapAct, status, err := soapActionHeader(r)
+		if soapAct != test.soapAct || status != test.status || (err == nil) != (status == http.StatusOK) {
+			t.Errorf("%s: soapActionHeader returned '%s', %d, %v, expected '%s', %d", test.name, soapAct, status, err, test.soapAct, test.status)
+		}
+	}
+}
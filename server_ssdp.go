@@ -2,20 +2,62 @@ package yuppie
 
 import (
 	"fmt"
+	"net"
+	"strconv"
 
 	"github.com/pkg/errors"
 	"gitlab.com/mipimipi/yuppie/desc"
-	"gitlab.com/mipimipi/yuppie/internal/network"
 	"gitlab.com/mipimipi/yuppie/internal/ssdp"
 )
 
+// SSDPMsgKind represents the kind of an SSDP message
+type SSDPMsgKind int
+
+const (
+	// SSDPAlive is the kind of ssdp:alive notifications
+	SSDPAlive SSDPMsgKind = iota
+	// SSDPByeBye is the kind of ssdp:byebye notifications
+	SSDPByeBye
+	// SSDPSearchResponse is the kind of responses to search requests
+	SSDPSearchResponse
+)
+
+// ssdpMsgKinds maps SSDP message kinds to the corresponding kinds of the SSDP
+// server
+var ssdpMsgKinds = map[SSDPMsgKind]ssdp.MsgKind{
+	SSDPAlive:          ssdp.KindAlive,
+	SSDPByeBye:         ssdp.KindByeBye,
+	SSDPSearchResponse: ssdp.KindResponse,
+}
+
+// SSDPHeader registers an additional header field (e.g. X-User-Agent, OPT,
+// 01-NLS, SECURELOCATION.UPNP.ORG or a custom X- field) with the value value
+// for SSDP messages of kind kind. If target is not empty, the field is only
+// added to notifications with that NT or search responses with that ST
+func (me *Server) SSDPHeader(kind SSDPMsgKind, target, name, value string) (err error) {
+	k, exists := ssdpMsgKinds[kind]
+	if !exists {
+		err = fmt.Errorf("unknown SSDP message kind: %d", kind)
+		log.Error(err)
+		return
+	}
+	if err = me.ssdpHeaders.Add(k, target, name, value); err != nil {
+		err = errors.Wrap(err, "cannot register SSDP header")
+		log.Error(err)
+		return
+	}
+
+	log.Tracef("registered SSDP header field '%s'", name)
+	return
+}
+
 // createSSDPServers creates SSDP server. One for each suitable network
 // interface. If no suitable interface is available, the function returns an#
 // error
 func (me *Server) createSSDPServers() (err error) {
 	log.Trace("creating SSDP servers")
 
-	infs, err := network.Interfaces(me.cfg.Interfaces)
+	opts, err := me.ssdpOptions()
 	if err != nil {
 		err = errors.Wrap(err, "cannot create SSDP servers")
 		log.Fatal(err)
@@ -26,8 +68,11 @@ func (me *Server) createSSDPServers() (err error) {
 	data := me.createDiscoveryData()
 	index := me.createSearchIndex()
 
-	for _, inf := range infs {
-		ssdp, err := ssdp.New(data, index, me.bootID, me.configID, inf, me.cfg.Port)
+	for _, inf := range me.infs {
+		// the location of the device description depends on the interface
+		data.Location = me.baseURL(inf.Name, inf.IP).String() + deviceDescPath
+
+		ssdp, err := ssdp.New(data, index, me.bootID, me.configID, inf.Interface, inf.IP, opts)
 		if err != nil {
 			err = errors.Wrapf(err, "cannot create SSDP server for interface %s", inf.Name)
 			log.Error(err)
@@ -47,13 +92,40 @@ func (me *Server) createSSDPServers() (err error) {
 	return
 }
 
+// ssdpOptions assembles the options for the SSDP servers from the
+// configuration
+func (me *Server) ssdpOptions() (opts ssdp.Options, err error) {
+	opts.UnicastOnly = me.cfg.SSDPUnicastOnly
+	opts.Headers = me.ssdpHeaders
+	if me.cfg.Access.SSDP {
+		opts.Accept = func(ip net.IP) bool { return me.access.allowed(ip, "", "") }
+	}
+
+	for _, peer := range me.cfg.SSDPPeers {
+		if _, _, e := net.SplitHostPort(peer); e != nil {
+			peer = net.JoinHostPort(peer, strconv.Itoa(ssdp.Port))
+		}
+		addr, err := net.ResolveUDPAddr("udp4", peer)
+		if err != nil {
+			return opts, errors.Wrapf(err, "cannot resolve SSDP peer '%s'", peer)
+		}
+		opts.Peers = append(opts.Peers, addr)
+	}
+
+	if opts.UnicastOnly && len(opts.Peers) == 0 {
+		err = fmt.Errorf("SSDP unicast only mode requires at least one peer")
+		return
+	}
+
+	return
+}
+
 // createDiscoveryData creates the data from server that is required by SSDP
 // for discovery messages
 func (me *Server) createDiscoveryData() (data ssdp.DiscoveryData) {
 	data.Server = me.ServerString()
-	// {{ADDRESS}} will be replaced by the SSDP server with the address of the
-	// network interface of that server
-	data.Location = httpProtocol + "://{{ADDRESS}}" + deviceDescPath
+	// note: the location is set per network interface when the SSDP servers
+	// are created
 	data.MaxAge = me.cfg.MaxAge
 	data.AssIDs = getDeviceAssets(&me.Device.Desc.Device, true)
 
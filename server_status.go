@@ -3,7 +3,6 @@ package yuppie
 import (
 	"encoding/json"
 	"os"
-	"strings"
 
 	"github.com/pkg/errors"
 	"gitlab.com/go-utilities/file"
@@ -169,7 +168,7 @@ func (me *Server) writeStatus() error {
 	for id, svc := range me.services {
 		vars := make(map[string]string)
 		for name, statVar := range svc.stateVars {
-			if strings.ToUpper(name)[:11] == "A_ARG_TYPE_" {
+			if isArgType(name) {
 				// according to the UPnP Device Architecture 2.0 spec, state
 				// variables whose name starts wirh "A_ARG_TYPE_" do only exist
 				// to assign a type to an argument. It makes no sense to store
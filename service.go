@@ -1,8 +1,11 @@
 package yuppie
 
 import (
+	"context"
 	"fmt"
+	"strconv"
 	"strings"
+	"time"
 
 	"gitlab.com/mipimipi/yuppie/desc"
 	"gitlab.com/mipimipi/yuppie/internal/events"
@@ -47,6 +50,15 @@ func newServiceVersion(t string) (serviceVersion, error) {
 	return serviceVersion(s[4]), nil
 }
 
+// number returns the version as number
+func (me serviceVersion) number() (int, error) {
+	n, err := strconv.Atoi(string(me))
+	if err != nil || n < 1 {
+		return 0, fmt.Errorf("invalid service version: %s", me)
+	}
+	return n, nil
+}
+
 // service returns a UPnP service
 type service struct {
 	id        serviceID
@@ -54,23 +66,58 @@ type service struct {
 	ver       serviceVersion
 	device    *device
 	actSpecs  map[string]map[string](*stateVar)
+	inSpecs   map[string][]argSpec
+	outSpecs  map[string][]argSpec
 	stateVars map[string](*stateVar)
-	desc      *desc.Service
+	errDescs  map[UPnPErrorCode]string
+	timeouts  map[string]time.Duration
+	// since contains the service versions that actions were introduced with.
+	// Actions that are not contained exist since version 1
+	since map[string]int
+	// serial serializes the calls of SOAP handlers: A call occupies its only
+	// slot while the handler runs. It's nil if the calls are not serialized
+	serial chan struct{}
+	desc   *desc.Service
+}
+
+// argSpec represents the specification of an action argument: Its name and
+// the related state variable
+type argSpec struct {
+	name string
+	sv   *stateVar
 }
 
 // serviceMap map a service id (only the pure id part) to the corresponding
 // service
 type serviceMap map[string]*service
 
+// hasAction returns true if service svcID exists and has action act. An empty
+// act only requires the service to exist. QueryStateVariable is an action of
+// every service
+func (me serviceMap) hasAction(svcID, act string) bool {
+	svc, exists := me[svcID]
+	if !exists {
+		return false
+	}
+	if act == "" || act == queryStateVarAct {
+		return true
+	}
+	_, exists = svc.actSpecs[act]
+	return exists
+}
+
 // newService creates a new service for a certain id, type and version, based
 // on a service description. A listener for multicast eventing is assigned to
 // the state variables of the service. It returns a reference to the service.
 func newService(id serviceID, typ serviceType, ver serviceVersion, svcDesc *desc.Service, listener func() chan events.StateVar) (*service, error) {
 	svc := service{
-		id:   id,
-		typ:  typ,
-		ver:  ver,
-		desc: svcDesc,
+		id:       id,
+		typ:      typ,
+		ver:      ver,
+		errDescs: make(map[UPnPErrorCode]string),
+		timeouts: make(map[string]time.Duration),
+		since:    make(map[string]int),
+		desc:     svcDesc,
 	}
 
 	// create statevars map
@@ -82,8 +129,10 @@ func newService(id serviceID, typ serviceType, ver serviceVersion, svcDesc *desc
 		}
 	}
 
-	// create actions map
+	// create actions maps
 	svc.actSpecs = make(map[string]map[string](*stateVar))
+	svc.inSpecs = make(map[string][]argSpec)
+	svc.outSpecs = make(map[string][]argSpec)
 	for _, act := range svcDesc.Actions {
 		if _, exists := svc.actSpecs[act.Name]; exists {
 			err := fmt.Errorf("action with name '%s' exists already", act.Name)
@@ -93,21 +142,130 @@ func newService(id serviceID, typ serviceType, ver serviceVersion, svcDesc *desc
 		args := make(map[string](*stateVar))
 
 		svc.actSpecs[act.Name] = args
+		svc.inSpecs[act.Name] = []argSpec{}
+		svc.outSpecs[act.Name] = []argSpec{}
 		// retrieve action arguments
 		for _, arg := range act.Arguments {
-			// only input arguments are relevant
-			if arg.Direction != "in" {
-				continue
-			}
 			// retrieve corresponding state variable
 			sv, exists := svc.stateVars[arg.RelatedStateVariable]
 			if !exists {
 				err := fmt.Errorf("state variable '%s' for argument '%s' not found", arg.RelatedStateVariable, arg.Name)
 				return nil, err
 			}
+			// arguments are kept in the order of the service description
+			// since requests and responses must adhere to it
+			if arg.Direction == "out" {
+				svc.outSpecs[act.Name] = append(svc.outSpecs[act.Name], argSpec{arg.Name, sv})
+				continue
+			}
+			svc.inSpecs[act.Name] = append(svc.inSpecs[act.Name], argSpec{arg.Name, sv})
 			svc.actSpecs[act.Name][arg.Name] = sv
 		}
 	}
 
 	return &svc, nil
 }
+
+// respArgs checks the output arguments args of the action act against the
+// service description and returns them in the order of the description. If an
+// argument is missing or unknown or if its value is not valid for the related
+// state variable, an error is returned
+func (me *service) respArgs(act string, args SOAPRespArgs) (resp []soapArg, err error) {
+	specs := me.outSpecs[act]
+
+	for _, spec := range specs {
+		value, exists := args[spec.name]
+		if !exists {
+			err = fmt.Errorf("output argument '%s' of action '%s' is missing", spec.name, act)
+			return
+		}
+		if isValid, _ := spec.sv.IsValid(value); !isValid {
+			err = fmt.Errorf("output argument '%s' of action '%s' has invalid value '%s'", spec.name, act, value)
+			return
+		}
+		resp = append(resp, soapArg{Name: spec.name, Value: value})
+	}
+
+	// check for arguments that are not part of the description
+	if len(resp) < len(args) {
+		for name := range args {
+			known := false
+			for _, spec := range specs {
+				if spec.name == name {
+					known = true
+					break
+				}
+			}
+			if !known {
+				err = fmt.Errorf("unknown output argument '%s' of action '%s'", name, act)
+				return
+			}
+		}
+	}
+
+	return
+}
+
+// completeError sets the description of soapErr if it's empty. The
+// descriptions that were registered for the service take precedence over the
+// default descriptions
+func (me *service) completeError(soapErr SOAPError) SOAPError {
+	if soapErr.Desc != "" {
+		return soapErr
+	}
+	if desc, exists := me.errDescs[soapErr.Code]; exists {
+		soapErr.Desc = desc
+		return soapErr
+	}
+	soapErr.Desc = soapErr.Code.String()
+	return soapErr
+}
+
+// isGetter returns true if the action act simply returns the values of state
+// variables. That's the case if the output arguments map one-to-one onto
+// related state variables (that are no A_ARG_TYPE_ variables) and if the action
+// has no input arguments or only InstanceID
+func (me *service) isGetter(act string) bool {
+	in := me.inSpecs[act]
+	if len(in) > 1 || (len(in) == 1 && in[0].name != "InstanceID") {
+		return false
+	}
+
+	out := me.outSpecs[act]
+	if len(out) == 0 {
+		return false
+	}
+	svs := make(map[*stateVar]bool)
+	for _, spec := range out {
+		if isArgType(spec.sv.name) || svs[spec.sv] {
+			return false
+		}
+		svs[spec.sv] = true
+	}
+	return true
+}
+
+// getter returns a SOAP handler for the getter action act. The handler returns
+// the current values of the related state variables of the output arguments.
+// Note: The state variables have one value for all instances. Thus, only the
+// instance 0 is supported. For other values of InstanceID, error 718 is
+// returned
+func (me *service) getter(act string) SOAPHandler {
+	specs := me.outSpecs[act]
+	return func(_ context.Context, req *SOAPRequest) (SOAPRespArgs, SOAPError) {
+		if id, exists := req.Args["InstanceID"]; exists && id.String() != "0" {
+			return nil, SOAPError{
+				Code: UPnPErrorInvalidInstanceID,
+				Desc: fmt.Sprintf("instance %s does not exist", id.String()),
+			}
+		}
+
+		args := make(SOAPRespArgs)
+		for _, spec := range specs {
+			spec.sv.StateVar.Lock()
+			args[spec.name] = spec.sv.String()
+			spec.sv.StateVar.Unlock()
+		}
+		return args, SOAPError{}
+	}
+}
@@ -0,0 +1,53 @@
+package yuppie
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestRespArgs(t *testing.T) {
+	svc := newTestServer(t).services["ContentDirectory"]
+
+	tests := []struct {
+		name string
+		args SOAPRespArgs
+		resp string
+		ok   bool
+	}{
+		{
+			"order of the service description",
+			SOAPRespArgs{"UpdateID": "3", "TotalMatches": "2", "Result": "<DIDL-Lite/>", "NumberReturned": "1"},
+			"[{Result <DIDL-Lite/>} {NumberReturned 1} {TotalMatches 2} {UpdateID 3}]",
+			true,
+		},
+		{
+			"missing argument",
+			SOAPRespArgs{"Result": "", "NumberReturned": "1", "TotalMatches": "2"},
+			"",
+			false,
+		},
+		{
+			"invalid value",
+			SOAPRespArgs{"Result": "", "NumberReturned": "one", "TotalMatches": "2", "UpdateID": "3"},
+			"",
+			false,
+		},
+		{
+			"unknown argument",
+			SOAPRespArgs{"Result": "", "NumberReturned": "1", "TotalMatches": "2", "UpdateID": "3", "Count": "4"},
+			"",
+			false,
+		},
+	}
+
+	for _, test := range tests {
+		resp, err := svc.respArgs("Browse", test.args)
+		if (err == nil) != test.ok {
+			t.Errorf("%s: respArgs returned error %v, expected success %t", test.name, err, test.ok)
+			continue
+		}
+		if test.ok && fmt.Sprint(resp) != test.resp {
+			t.Errorf("%s: respArgs returned %v, expected %s", test.name, resp, test.resp)
+		}
+	}
+}
@@ -0,0 +1,253 @@
+package yuppie
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const soapEnvNS = "http://schemas.xmlsoap.org/soap/envelope/"
+
+// maxPooledBufSize is the maximum capacity of buffers that are put back into
+// the pool. Larger buffers (e.g. for huge Browse results) are left to the
+// garbage collector to not keep too much memory allocated
+const maxPooledBufSize = 4 << 20
+
+// soapWriter is the destination SOAP documents are rendered to. It's
+// implemented by bytes.Buffer (for responses with content length) and by
+// bufio.Writer (for chunked responses)
+type soapWriter interface {
+	io.Writer
+	io.StringWriter
+	io.ByteWriter
+}
+
+// bufPool contains buffers for rendering SOAP documents
+var bufPool = sync.Pool{
+	New: func() interface{} { return new(bytes.Buffer) },
+}
+
+// getBuf returns an empty buffer from the pool
+func getBuf() *bytes.Buffer {
+	buf := bufPool.Get().(*bytes.Buffer)
+	buf.Reset()
+	return buf
+}
+
+// putBuf puts buf back into the pool
+func putBuf(buf *bytes.Buffer) {
+	if buf.Cap() > maxPooledBufSize {
+		return
+	}
+	bufPool.Put(buf)
+}
+
+// errLimit is returned by decodeSOAPAction if the SOAP document exceeds one
+// of the limits
+type errLimit struct {
+	msg string
+}
+
+func (me errLimit) Error() string {
+	return me.msg
+}
+
+// errTooManyArgs is returned by decodeSOAPAction if the action has more
+// arguments than allowed
+type errTooManyArgs struct {
+	n int
+}
+
+func (me errTooManyArgs) Error() string {
+	return fmt.Sprintf("SOAP action has more than %d arguments", me.n)
+}
+
+// decodeSOAPAction reads a SOAP action call from r. The document is decoded
+// in one pass: Envelope and Body are skipped, the first element in the body is
+// the action and its child elements are the arguments. If the document
+// exceeds the limits lim, an error of type errLimit or errTooManyArgs is
+// returned
+func decodeSOAPAction(r io.Reader, lim Limits) (action soapAct, err error) {
+	d := xml.NewDecoder(r)
+
+	var (
+		// depth is 0 outside of the envelope, 1 in the envelope, 2 in the
+		// body or header, 3 in the action element and 4 in an argument
+		depth  int
+		inBody bool
+		tokens int
+		value  []byte
+	)
+	for {
+		var tok xml.Token
+		if tok, err = d.Token(); err != nil {
+			if err == io.EOF {
+				err = fmt.Errorf("incomplete SOAP document")
+			}
+			err = errors.Wrap(err, "cannot decode SOAP document")
+			return
+		}
+
+		if tokens++; tokens > lim.MaxXMLTokens {
+			err = errLimit{fmt.Sprintf("SOAP document has more than %d XML tokens", lim.MaxXMLTokens)}
+			return
+		}
+
+		switch t := tok.(type) {
+		case xml.StartElement:
+			if depth++; depth > lim.MaxXMLDepth {
+				err = errLimit{fmt.Sprintf("SOAP document is nested deeper than %d levels", lim.MaxXMLDepth)}
+				return
+			}
+			switch {
+			case depth == 1:
+				if t.Name.Space != soapEnvNS || t.Name.Local != "Envelope" {
+					err = fmt.Errorf("SOAP document does not start with an envelope but with '%s'", t.Name.Local)
+					return
+				}
+			case depth == 2:
+				// note: Other elements (e.g. SOAP header) are skipped
+				inBody = t.Name.Space == soapEnvNS && t.Name.Local == "Body"
+			case depth == 4 && inBody:
+				if len(action.Args) == lim.MaxArgs {
+					err = errTooManyArgs{lim.MaxArgs}
+					return
+				}
+				action.Args = append(action.Args, soapArg{Name: t.Name.Local})
+				value = value[:0]
+			case depth > 4 && inBody:
+				err = fmt.Errorf("argument '%s' must not contain elements", action.Args[len(action.Args)-1].Name)
+				return
+			}
+		case xml.CharData:
+			if depth == 4 && inBody {
+				value = append(value, t...)
+			}
+		case xml.EndElement:
+			if inBody {
+				switch depth {
+				case 4:
+					action.Args[len(action.Args)-1].Value = string(value)
+				case 3:
+					// end of action element reached: the rest of the document
+					// is not of interest
+					return
+				case 2:
+					err = fmt.Errorf("SOAP body does not contain an action")
+					return
+				}
+			}
+			depth--
+		}
+	}
+}
+
+// writeEnvelopeStart writes the beginning of a SOAP envelope incl. the XML
+// declaration and the start of the body to buf
+func writeEnvelopeStart(buf soapWriter) {
+	buf.WriteString(`<?xml version="1.0" encoding="utf-8"?>`)
+	buf.WriteString(`<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/" s:encodingStyle="http://schemas.xmlsoap.org/soap/encoding/">`)
+	buf.WriteString("<s:Body>")
+}
+
+// writeEnvelopeEnd writes the end of a SOAP body and envelope to buf
+func writeEnvelopeEnd(buf soapWriter) {
+	buf.WriteString("</s:Body>")
+	buf.WriteString("</s:Envelope>")
+}
+
+// xmlEscapes maps the special XML characters to their escape sequences
+var xmlEscapes = [256]string{
+	'&':  "&amp;",
+	'<':  "&lt;",
+	'>':  "&gt;",
+	'"':  "&#34;",
+	'\'': "&#39;",
+}
+
+// writeEscaped writes s to buf with the special XML characters escaped. In
+// contrast to html.EscapeString, no copy of s is created
+func writeEscaped(buf soapWriter, s string) {
+	last := 0
+	for i := 0; i < len(s); i++ {
+		esc := xmlEscapes[s[i]]
+		if esc == "" {
+			continue
+		}
+		_, _ = buf.WriteString(s[last:i])
+		_, _ = buf.WriteString(esc)
+		last = i + 1
+	}
+	_, _ = buf.WriteString(s[last:])
+}
+
+// sendSOAPDocument renders a SOAP document with encode into a pooled buffer
+// and writes it to w with HTTP status status. The document is rendered only
+// once: The length of the buffer is used as content length
+func (me *Server) sendSOAPDocument(w http.ResponseWriter, status int, encode func(soapWriter)) (err error) {
+	buf := getBuf()
+	defer putBuf(buf)
+
+	encode(buf)
+
+	setHeader(w, me.ServerString(), buf.Len())
+	w.WriteHeader(status)
+	if _, err = w.Write(buf.Bytes()); err != nil {
+		err = errors.Wrap(err, "SOAP document cannot be written to HTTP response")
+	}
+	return
+}
+
+// getChunkWriter returns a buffered writer from the pool of the server that
+// writes to w. Its buffer has a size of ChunkSize bytes
+func (me *Server) getChunkWriter(w io.Writer) *bufio.Writer {
+	bw, ok := me.chunkWriters.Get().(*bufio.Writer)
+	if !ok {
+		return bufio.NewWriterSize(w, me.cfg.ChunkSize)
+	}
+	bw.Reset(w)
+	return bw
+}
+
+// putChunkWriter puts bw back into the pool of the server
+func (me *Server) putChunkWriter(bw *bufio.Writer) {
+	bw.Reset(nil)
+	me.chunkWriters.Put(bw)
+}
+
+// streamSOAPDocument renders a SOAP document with encode directly into w,
+// using chunked transfer encoding. The document is not buffered completely but
+// passed to w whenever ChunkSize bytes are rendered. Note: The sizes of the
+// chunks on the wire are determined by net/http. Streaming requires that the
+// client uses HTTP/1.1
+func (me *Server) streamSOAPDocument(w http.ResponseWriter, status int, encode func(soapWriter)) (err error) {
+	w.Header().Set("server", me.ServerString())
+	w.Header().Set("date", time.Now().Format(time.RFC1123))
+	w.Header().Set("content-type", "text/xml; charset=\"utf-8\"")
+	w.Header().Set("transfer-encoding", "chunked")
+	w.WriteHeader(status)
+
+	bw := me.getChunkWriter(w)
+	defer me.putChunkWriter(bw)
+	encode(bw)
+	if err = bw.Flush(); err != nil {
+		err = errors.Wrap(err, "SOAP document cannot be written to HTTP response")
+	}
+	return
+}
+
+// sendSOAPResponse sends the response resp to a SOAP action. Large responses
+// are sent chunked if this is configured and if the client uses HTTP/1.1
+func (me *Server) sendSOAPResponse(w http.ResponseWriter, r *http.Request, resp soapActResp) error {
+	if me.cfg.ChunkSize > 0 && r.ProtoAtLeast(1, 1) && resp.size() > me.cfg.ChunkSize {
+		return me.streamSOAPDocument(w, http.StatusOK, resp.encode)
+	}
+	return me.sendSOAPDocument(w, http.StatusOK, resp.encode)
+}
@@ -0,0 +1,253 @@
+package yuppie
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"html"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// browseRequest is the SOAP document of a typical Browse request
+const browseRequest = `<?xml version="1.0" encoding="utf-8"?>
+<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/" s:encodingStyle="http://schemas.xmlsoap.org/soap/encoding/">
+<s:Body>
+<u:Browse xmlns:u="urn:schemas-upnp-org:service:ContentDirectory:1">
+<ObjectID>64$1$2</ObjectID>
+<BrowseFlag>BrowseDirectChildren</BrowseFlag>
+<Filter>dc:title,upnp:class,upnp:album,upnp:artist,res@duration,res@size</Filter>
+<StartingIndex>0</StartingIndex>
+<RequestedCount>5000</RequestedCount>
+<SortCriteria>+dc:title</SortCriteria>
+</u:Browse>
+</s:Body>
+</s:Envelope>`
+
+// discardWriter is an http.ResponseWriter that discards everything written to
+// it
+type discardWriter struct {
+	header http.Header
+}
+
+func (me *discardWriter) Header() http.Header         { return me.header }
+func (me *discardWriter) Write(p []byte) (int, error) { return io.Discard.Write(p) }
+func (me *discardWriter) WriteHeader(int)             {}
+
+// browseResponse returns the response to a Browse request with n items. Its
+// DIDL-Lite result is about n*500 bytes large
+func browseResponse(n int) soapActResp {
+	var didl strings.Builder
+	didl.WriteString(`<DIDL-Lite xmlns="urn:schemas-upnp-org:metadata-1-0/DIDL-Lite/" xmlns:dc="http://purl.org/dc/elements/1.1/" xmlns:upnp="urn:schemas-upnp-org:metadata-1-0/upnp/">`)
+	for i := 0; i < n; i++ {
+		fmt.Fprintf(&didl, `<item id="64$1$2$%d" parentID="64$1$2" restricted="1"><dc:title>Track %d &amp; more</dc:title><upnp:class>object.item.audioItem.musicTrack</upnp:class><upnp:album>Album</upnp:album><upnp:artist>Artist</upnp:artist><res duration="0:03:30.000" size="8400000" protocolInfo="http-get:*:audio/flac:*">http://192.168.1.10:8008/media/%d.flac</res></item>`, i, i, i)
+	}
+	didl.WriteString(`</DIDL-Lite>`)
+
+	return soapActResp{
+		namespace: "urn:schemas-upnp-org:service:ContentDirectory:1",
+		name:      "Browse",
+		args: []soapArg{
+			{Name: "Result", Value: didl.String()},
+			{Name: "NumberReturned", Value: fmt.Sprint(n)},
+			{Name: "TotalMatches", Value: fmt.Sprint(n)},
+			{Name: "UpdateID", Value: "1"},
+		},
+	}
+}
+
+// refSOAPEnv, refSOAPAct and refSOAPArg are used by the reference
+// implementation of decoding and encoding SOAP documents with encoding/xml and
+// fmt. It's how SOAP documents were handled before the codec existed and
+// serves as baseline for the benchmarks
+type refSOAPEnv struct {
+	XMLName xml.Name `xml:"http://schemas.xmlsoap.org/soap/envelope/ Envelope"`
+	Body    struct {
+		Content []byte `xml:",innerxml"`
+	} `xml:"Body"`
+}
+
+type refSOAPAct struct {
+	Args []refSOAPArg `xml:",any"`
+}
+
+type refSOAPArg soapArg
+
+func (me *refSOAPArg) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	me.Name = start.Name.Local
+	return d.DecodeElement(&me.Value, &start)
+}
+
+// refDecodeSOAPAction reads a SOAP action call from r completely and
+// unmarshals envelope and action in two passes
+func refDecodeSOAPAction(r io.Reader) (action soapAct, err error) {
+	body, err := io.ReadAll(r)
+	if err != nil {
+		return
+	}
+	var env refSOAPEnv
+	if err = xml.Unmarshal(body, &env); err != nil {
+		return
+	}
+	var act refSOAPAct
+	if err = xml.Unmarshal(env.Body.Content, &act); err != nil {
+		return
+	}
+	for _, arg := range act.Args {
+		action.Args = append(action.Args, soapArg(arg))
+	}
+	return
+}
+
+// refMarshal marshals the SOAP document of resp into a new byte slice
+func refMarshal(resp soapActResp) []byte {
+	content := new(bytes.Buffer)
+	fmt.Fprintf(content, "<u:%sResponse xmlns:u=\"%s\">", resp.name, resp.namespace)
+	for _, arg := range resp.args {
+		fmt.Fprintf(content, "<%s>%s</%s>", arg.Name, html.EscapeString(arg.Value), arg.Name)
+	}
+	fmt.Fprintf(content, "</u:%sResponse>", resp.name)
+
+	buf := new(bytes.Buffer)
+	fmt.Fprint(buf, "<?xml version=\"1.0\" encoding=\"utf-8\"?>")
+	fmt.Fprint(buf, "<s:Envelope xmlns:s=\"http://schemas.xmlsoap.org/soap/envelope/\" s:encodingStyle=\"http://schemas.xmlsoap.org/soap/encoding/\">")
+	fmt.Fprint(buf, "<s:Body>")
+	_, _ = buf.Write(content.Bytes())
+	fmt.Fprint(buf, "</s:Body>")
+	fmt.Fprint(buf, "</s:Envelope>")
+	return buf.Bytes()
+}
+
+func TestCodecMatchesReference(t *testing.T) {
+	action, err := decodeSOAPAction(strings.NewReader(browseRequest), Limits{}.withDefaults())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ref, err := refDecodeSOAPAction(strings.NewReader(browseRequest))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(action.Args) != fmt.Sprint(ref.Args) {
+		t.Errorf("decoded arguments are %v, expected %v", action.Args, ref.Args)
+	}
+
+	resp := browseResponse(3)
+	resp.args = append(resp.args, soapArg{Name: "Quotes", Value: `'a' "b"`})
+	buf := new(bytes.Buffer)
+	resp.encode(buf)
+	if !bytes.Equal(buf.Bytes(), refMarshal(resp)) {
+		t.Errorf("encoded response is\n%s\nexpected\n%s", buf.Bytes(), refMarshal(resp))
+	}
+}
+
+func TestDecodeSOAPActionLimits(t *testing.T) {
+	env := func(body string) string {
+		return `<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/"><s:Body>` + body + `</s:Body></s:Envelope>`
+	}
+	const browse = `<u:Browse xmlns:u="urn:schemas-upnp-org:service:ContentDirectory:1"><ObjectID>0</ObjectID><BrowseFlag>BrowseMetadata</BrowseFlag></u:Browse>`
+
+	// kinds of errors
+	const (
+		none = iota
+		limit
+		tooManyArgs
+		invalid
+	)
+
+	tests := []struct {
+		name string
+		doc  string
+		lim  Limits
+		args int
+		err  int
+	}{
+		{"within limits", env(browse), Limits{}, 2, none},
+		{"SOAP header is skipped", `<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/"><s:Header><x>1</x></s:Header><s:Body>` + browse + `</s:Body></s:Envelope>`, Limits{}, 2, none},
+		{"exactly MaxArgs arguments", env(browse), Limits{MaxArgs: 2}, 2, none},
+		{"more than MaxArgs arguments", env(browse), Limits{MaxArgs: 1}, 0, tooManyArgs},
+		{"deeper than MaxXMLDepth", env(`<u:Browse xmlns:u="urn:x"><ObjectID><a>0</a></ObjectID></u:Browse>`), Limits{MaxXMLDepth: 4}, 0, limit},
+		{"element in argument", env(`<u:Browse xmlns:u="urn:x"><ObjectID><a>0</a></ObjectID></u:Browse>`), Limits{}, 0, invalid},
+		{"more than MaxXMLTokens", env(browse), Limits{MaxXMLTokens: 8}, 0, limit},
+		{"no envelope", browse, Limits{}, 0, invalid},
+		{"no action", env(""), Limits{}, 0, invalid},
+		{"incomplete document", env(browse)[:100], Limits{}, 0, invalid},
+	}
+
+	for _, test := range tests {
+		action, err := decodeSOAPAction(strings.NewReader(test.doc), test.lim.withDefaults())
+		kind := invalid
+		switch err.(type) {
+		case nil:
+			kind = none
+		case errLimit:
+			kind = limit
+		case errTooManyArgs:
+			kind = tooManyArgs
+		}
+		if kind != test.err {
+			t.Errorf("%s: decodeSOAPAction returned error %v, expected error kind %d", test.name, err, test.err)
+			continue
+		}
+		if err == nil && len(action.Args) != test.args {
+			t.Errorf("%s: decodeSOAPAction returned %d arguments, expected %d", test.name, len(action.Args), test.args)
+		}
+	}
+}
+
+func BenchmarkDecodeSOAPAction(b *testing.B) {
+	body := []byte(browseRequest)
+	lim := Limits{}.withDefaults()
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := decodeSOAPAction(bytes.NewReader(body), lim); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkDecodeSOAPActionReference(b *testing.B) {
+	body := []byte(browseRequest)
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := refDecodeSOAPAction(bytes.NewReader(body)); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkSendSOAPResponse(b *testing.B) {
+	srv := &Server{}
+	resp := browseResponse(5000)
+	r := httptest.NewRequest(http.MethodPost, "/", nil)
+	w := &discardWriter{header: make(http.Header)}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := srv.sendSOAPResponse(w, r, resp); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkSendSOAPResponseReference(b *testing.B) {
+	srv := &Server{}
+	resp := browseResponse(5000)
+	w := &discardWriter{header: make(http.Header)}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		body := refMarshal(resp)
+		setHeader(w, srv.ServerString(), len(body))
+		w.WriteHeader(http.StatusOK)
+		if _, err := w.Write(body); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
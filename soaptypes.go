@@ -1,66 +1,92 @@
 package yuppie
 
 import (
-	"bytes"
-	"encoding/xml"
+	"context"
 	"fmt"
-	"html"
+	"net"
+	"net/http"
+	"net/url"
 	"time"
 )
 
 // SOAPRespArgs maps argument name to argument value
 type SOAPRespArgs map[string]string
 
-// soapEnv represents a SOAP envelope
-type soapEnv struct {
-	XMLName       xml.Name `xml:"http://schemas.xmlsoap.org/soap/envelope/ Envelope"`
-	EncodingStyle string   `xml:"encodingStyle,attr"`
-	Body          soapBody `xml:"Body"`
-}
-
-// soapBody represents a SOAP body
-type soapBody struct {
-	Content []byte `xml:",innerxml"`
-}
-
-// marshal marshals an XML snippet for a SOAP Body
-func (me soapEnv) marshal() []byte {
-	buf := new(bytes.Buffer)
-
-	fmt.Fprint(buf, "<?xml version=\"1.0\" encoding=\"utf-8\"?>")
-	fmt.Fprint(buf, "<s:Envelope xmlns:s=\"http://schemas.xmlsoap.org/soap/envelope/\" s:encodingStyle=\"http://schemas.xmlsoap.org/soap/encoding/\">")
-	fmt.Fprint(buf, "<s:Body>")
-	_, _ = buf.Write(me.Body.Content)
-	fmt.Fprint(buf, "</s:Body>")
-	fmt.Fprint(buf, "</s:Envelope>")
-
-	return buf.Bytes()
+// SOAPHandler is a handler function for SOAP actions. ctx is tied to the HTTP
+// request of the action call, i.e. it's cancelled if the client disconnects
+type SOAPHandler func(ctx context.Context, req *SOAPRequest) (SOAPRespArgs, SOAPError)
+
+// SOAPRequest represents the call of a SOAP action including metadata of the
+// corresponding HTTP request
+type SOAPRequest struct {
+	// ServiceID is the id of the service
+	ServiceID string
+	// Action is the name of the action
+	Action string
+	// Version is the service version that the control point requested. It can
+	// be lower than the version that is implemented by the server
+	Version int
+	// Args contains the input arguments of the action
+	Args map[string]StateVar
+	// RemoteAddr is the network address of the control point
+	RemoteAddr string
+	// LocalAddr is the local network address the request came in on
+	LocalAddr net.Addr
+	// Interface is the name of the network interface the request came in on
+	Interface string
+	// Host is the value of the HOST header field
+	Host string
+	// Header contains the HTTP header fields of the request, such as
+	// USER-AGENT or ACCEPT-LANGUAGE
+	Header http.Header
+	// BaseURL is the URL under which the server is reachable for the control
+	// point. It can be used to assemble absolute URLs, e.g. for resources in
+	// results of Browse actions
+	BaseURL *url.URL
 }
 
 // soapAct represents a SOAP action
 type soapAct struct {
-	Args []soapArg `xml:",any"`
+	Args []soapArg
 }
 
 // soapActionResponse represents a response to a SOAP action
 type soapActResp struct {
-	serviceType string
-	serviceVer  string
-	name        string
-	args        SOAPRespArgs
+	namespace string
+	name      string
+	args      []soapArg
 }
 
-// marshal marshals an XML snippet for an response to a SOAP action
-func (me soapActResp) marshal() []byte {
-	buf := new(bytes.Buffer)
-
-	fmt.Fprintf(buf, "<u:%sResponse xmlns:u=\"%s:%s\">", me.name, me.serviceType, me.serviceVer)
-	for name, value := range me.args {
-		fmt.Fprintf(buf, "<%s>%s</%s>", name, html.EscapeString(value), name)
+// encode writes the SOAP document for the response to a SOAP action to buf
+func (me soapActResp) encode(buf soapWriter) {
+	writeEnvelopeStart(buf)
+	buf.WriteString("<u:")
+	buf.WriteString(me.name)
+	buf.WriteString("Response xmlns:u=\"")
+	buf.WriteString(me.namespace)
+	buf.WriteString("\">")
+	for _, arg := range me.args {
+		buf.WriteByte('<')
+		buf.WriteString(arg.Name)
+		buf.WriteByte('>')
+		writeEscaped(buf, arg.Value)
+		buf.WriteString("</")
+		buf.WriteString(arg.Name)
+		buf.WriteByte('>')
 	}
-	fmt.Fprintf(buf, "</u:%sResponse>", me.name)
+	buf.WriteString("</u:")
+	buf.WriteString(me.name)
+	buf.WriteString("Response>")
+	writeEnvelopeEnd(buf)
+}
 
-	return soapEnv{Body: soapBody{Content: buf.Bytes()}}.marshal()
+// size returns the approximate size of the response document in bytes. It's
+// dominated by the values of the arguments
+func (me soapActResp) size() (n int) {
+	for _, arg := range me.args {
+		n += len(arg.Value)
+	}
+	return
 }
 
 // soapArg represents an argument of a SOAP action
@@ -69,20 +95,20 @@ type soapArg struct {
 	Value string
 }
 
-// UnmarshalXML unmarshals an argument of a SOAP action
-func (me *soapArg) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
-	me.Name = start.Name.Local
-	return d.DecodeElement(&me.Value, &start)
-}
-
 // UPnPErrorCode represents an UPnP error code
 type UPnPErrorCode uint
 
 const (
-	// UPnPErrorInvalidAction is the code foran invalid action
-	UPnPErrorInvalidAction UPnPErrorCode = 400
+	// UPnPErrorInvalidAction is the code for an invalid action
+	UPnPErrorInvalidAction UPnPErrorCode = 401
 	// UPnPErrorInvalidArgs is the code for invalid arguments
 	UPnPErrorInvalidArgs UPnPErrorCode = 402
+	// UPnPErrorOutOfSync is the code for an out of sync error. Note: The UPnP
+	// Device Architecture 2.0 marks this code as "do not use"
+	UPnPErrorOutOfSync UPnPErrorCode = 403
+	// UPnPErrorInvalidVar is the code for an unknown state variable in a
+	// QueryStateVariable call
+	UPnPErrorInvalidVar UPnPErrorCode = 404
 	// UPnPErrorActionFailed is the code for a failed action
 	UPnPErrorActionFailed UPnPErrorCode = 501
 	// UPnPErrorArgValInvalid is the code for an invalid argument value
@@ -93,12 +119,89 @@ const (
 	// UPnPErrorOptActionNotImplemented is the code for an action that is
 	// called but not implemented
 	UPnPErrorOptActionNotImplemented UPnPErrorCode = 602
+	// UPnPErrorOutOfMemory indicates that the device does not have sufficient
+	// memory to complete the action
+	UPnPErrorOutOfMemory UPnPErrorCode = 603
 	// UPnPErrorHumanRequired indicates that human interaction is required
 	UPnPErrorHumanRequired UPnPErrorCode = 604
 	// UPnPErrorStrTooLong indicates that a string is too long
 	UPnPErrorStrTooLong UPnPErrorCode = 605
+	// UPnPErrorActionNotAuthorized indicates that the action requested
+	// requires authorization and the sender was not authorized
+	UPnPErrorActionNotAuthorized UPnPErrorCode = 606
+	// UPnPErrorSignatureFailure indicates that the sender's signature failed
+	// to verify
+	UPnPErrorSignatureFailure UPnPErrorCode = 607
+	// UPnPErrorSignatureMissing indicates that the action requested requires
+	// a digital signature and there was none provided
+	UPnPErrorSignatureMissing UPnPErrorCode = 608
+	// UPnPErrorNotEncrypted indicates that the action requested requires
+	// confidentiality but the action was not delivered encrypted
+	UPnPErrorNotEncrypted UPnPErrorCode = 609
+	// UPnPErrorInvalidSequence indicates that the sequence number was invalid
+	UPnPErrorInvalidSequence UPnPErrorCode = 610
+	// UPnPErrorInvalidControlURL indicates that the control URL was invalid
+	UPnPErrorInvalidControlURL UPnPErrorCode = 611
+	// UPnPErrorNoSuchSession indicates that the session key reference is to a
+	// non-existent session
+	UPnPErrorNoSuchSession UPnPErrorCode = 612
 )
 
+// ranges of error codes that are specific to actions. Codes from 700 to 799
+// are defined by UPnP Forum working committees (e.g. ContentDirectory 701 "No
+// such object"), codes from 800 to 899 are defined by vendors
+const (
+	// UPnPErrorStdActionMin is the first code for errors that are defined in
+	// UPnP standard service specifications
+	UPnPErrorStdActionMin UPnPErrorCode = 700
+	// UPnPErrorStdActionMax is the last code for errors that are defined in
+	// UPnP standard service specifications
+	UPnPErrorStdActionMax UPnPErrorCode = 799
+	// UPnPErrorVendorActionMin is the first code for vendor-defined errors
+	UPnPErrorVendorActionMin UPnPErrorCode = 800
+	// UPnPErrorVendorActionMax is the last code for vendor-defined errors
+	UPnPErrorVendorActionMax UPnPErrorCode = 899
+)
+
+// UPnPErrorInvalidInstanceID is the code for an unknown instance. It's
+// defined by the AV services (e.g. AVTransport and ConnectionManager)
+const UPnPErrorInvalidInstanceID UPnPErrorCode = 718
+
+// upnpErrorDescs contains the default descriptions of the error codes as
+// defined in the UPnP Device Architecture 2.0
+var upnpErrorDescs = map[UPnPErrorCode]string{
+	UPnPErrorInvalidAction:           "Invalid Action",
+	UPnPErrorInvalidArgs:             "Invalid Args",
+	UPnPErrorOutOfSync:               "Out of Sync",
+	UPnPErrorInvalidVar:              "Invalid Var",
+	UPnPErrorActionFailed:            "Action Failed",
+	UPnPErrorArgValInvalid:           "Argument Value Invalid",
+	UPnPErrorArgValOutOfRange:        "Argument Value Out of Range",
+	UPnPErrorOptActionNotImplemented: "Optional Action Not Implemented",
+	UPnPErrorOutOfMemory:             "Out of Memory",
+	UPnPErrorHumanRequired:           "Human Intervention Required",
+	UPnPErrorStrTooLong:              "String Argument Too Long",
+	UPnPErrorActionNotAuthorized:     "Action not authorized",
+	UPnPErrorSignatureFailure:        "Signature failure",
+	UPnPErrorSignatureMissing:        "Signature missing",
+	UPnPErrorNotEncrypted:            "Not encrypted",
+	UPnPErrorInvalidSequence:         "Invalid sequence",
+	UPnPErrorInvalidControlURL:       "Invalid control URL",
+	UPnPErrorNoSuchSession:           "No such session",
+	UPnPErrorInvalidInstanceID:       "Invalid InstanceID",
+}
+
+// String returns the default description of the error code
+func (me UPnPErrorCode) String() string {
+	return upnpErrorDescs[me]
+}
+
+// isActionSpecific returns true if the error code is from one of the ranges
+// for action-specific errors
+func (me UPnPErrorCode) isActionSpecific() bool {
+	return me >= UPnPErrorStdActionMin && me <= UPnPErrorVendorActionMax
+}
+
 // SOAPError represents a SOAP error
 type SOAPError struct {
 	Code UPnPErrorCode
@@ -110,21 +213,22 @@ func (me SOAPError) IsNil() bool {
 	return me.Code == 0 && me.Desc == ""
 }
 
-// marshal marshals an XML snippet for a SOAP error
-func (me SOAPError) marshal() []byte {
-	buf := new(bytes.Buffer)
-	fmt.Fprint(buf, "<s:Fault>")
-	fmt.Fprint(buf, "<faultcode>s:Client</faultcode>")
-	fmt.Fprint(buf, "<faultstring>UPnPError</faultstring>")
-	fmt.Fprint(buf, "<detail>")
-	fmt.Fprint(buf, "<UPnPError xmlns=\"urn:schemas-upnp-org:control-1-0\">")
+// encode writes the SOAP document for a SOAP fault to buf
+func (me SOAPError) encode(buf soapWriter) {
+	writeEnvelopeStart(buf)
+	buf.WriteString("<s:Fault>")
+	buf.WriteString("<faultcode>s:Client</faultcode>")
+	buf.WriteString("<faultstring>UPnPError</faultstring>")
+	buf.WriteString("<detail>")
+	buf.WriteString("<UPnPError xmlns=\"urn:schemas-upnp-org:control-1-0\">")
 	fmt.Fprintf(buf, "<errorCode>%d</errorCode>", me.Code)
-	fmt.Fprintf(buf, "<errorDescription>%s</errorDescription>", html.EscapeString(me.Desc))
-	fmt.Fprint(buf, "</UPnPError>")
-	fmt.Fprint(buf, "</detail>")
-	fmt.Fprint(buf, "</s:Fault>")
-
-	return soapEnv{Body: soapBody{Content: buf.Bytes()}}.marshal()
+	buf.WriteString("<errorDescription>")
+	writeEscaped(buf, me.Desc)
+	buf.WriteString("</errorDescription>")
+	buf.WriteString("</UPnPError>")
+	buf.WriteString("</detail>")
+	buf.WriteString("</s:Fault>")
+	writeEnvelopeEnd(buf)
 }
 
 // timeOfDay is used in cases where SOAP "time" or "time.tz" is used. This type
@@ -37,6 +37,25 @@ func newStateVar(typ, val string) (sv StateVar, err error) {
 	return f(val)
 }
 
+// MarshalValue returns the string representation of the Go value v for the
+// UPnP type typ. v must have the Go type that corresponds to typ (e.g. uint32
+// for ui4)
+func MarshalValue(typ string, v interface{}) (s string, err error) {
+	f, exists := marshalers[typ]
+	if !exists {
+		err = fmt.Errorf("cannot marshal value of unknown type '%s'", typ)
+		return
+	}
+	return f(v)
+}
+
+// isArgType returns true if name is the name of a state variable that only
+// exists to assign a type to an argument. According to the UPnP Device
+// Architecture 2.0, the names of such variables start with "A_ARG_TYPE_"
+func isArgType(name string) bool {
+	return strings.HasPrefix(strings.ToUpper(name), "A_ARG_TYPE_")
+}
+
 // StateVar represents a state variable
 type stateVar struct {
 	name            string
@@ -43,6 +43,179 @@ var constructors = map[string]func(string) (StateVar, error){
 	"uri": func(v string) (StateVar, error) { return newUpnpURI(v) },
 }
 
+// marshalers maps UPnP types to functions that marshal values of the
+// corresponding Go types
+var marshalers = map[string]func(interface{}) (string, error){ 
+	"ui1": func(v interface{}) (string, error) {
+		val, ok := v.(uint8)
+		if !ok {
+			return "", fmt.Errorf("expected type uint8, received: %s", reflect.TypeOf(v))
+		}
+		return marshalUpnpUI1(val)
+	},
+	"ui2": func(v interface{}) (string, error) {
+		val, ok := v.(uint16)
+		if !ok {
+			return "", fmt.Errorf("expected type uint16, received: %s", reflect.TypeOf(v))
+		}
+		return marshalUpnpUI2(val)
+	},
+	"ui4": func(v interface{}) (string, error) {
+		val, ok := v.(uint32)
+		if !ok {
+			return "", fmt.Errorf("expected type uint32, received: %s", reflect.TypeOf(v))
+		}
+		return marshalUpnpUI4(val)
+	},
+	"ui8": func(v interface{}) (string, error) {
+		val, ok := v.(uint64)
+		if !ok {
+			return "", fmt.Errorf("expected type uint64, received: %s", reflect.TypeOf(v))
+		}
+		return marshalUpnpUI8(val)
+	},
+	"i1": func(v interface{}) (string, error) {
+		val, ok := v.(int8)
+		if !ok {
+			return "", fmt.Errorf("expected type int8, received: %s", reflect.TypeOf(v))
+		}
+		return marshalUpnpI1(val)
+	},
+	"i2": func(v interface{}) (string, error) {
+		val, ok := v.(int16)
+		if !ok {
+			return "", fmt.Errorf("expected type int16, received: %s", reflect.TypeOf(v))
+		}
+		return marshalUpnpI2(val)
+	},
+	"i4": func(v interface{}) (string, error) {
+		val, ok := v.(int32)
+		if !ok {
+			return "", fmt.Errorf("expected type int32, received: %s", reflect.TypeOf(v))
+		}
+		return marshalUpnpI4(val)
+	},
+	"int": func(v interface{}) (string, error) {
+		val, ok := v.(int64)
+		if !ok {
+			return "", fmt.Errorf("expected type int64, received: %s", reflect.TypeOf(v))
+		}
+		return marshalUpnpInt(val)
+	},
+	"r4": func(v interface{}) (string, error) {
+		val, ok := v.(float32)
+		if !ok {
+			return "", fmt.Errorf("expected type float32, received: %s", reflect.TypeOf(v))
+		}
+		return marshalUpnpR4(val)
+	},
+	"r8": func(v interface{}) (string, error) {
+		val, ok := v.(float64)
+		if !ok {
+			return "", fmt.Errorf("expected type float64, received: %s", reflect.TypeOf(v))
+		}
+		return marshalUpnpR8(val)
+	},
+	"number": func(v interface{}) (string, error) {
+		val, ok := v.(float64)
+		if !ok {
+			return "", fmt.Errorf("expected type float64, received: %s", reflect.TypeOf(v))
+		}
+		return marshalUpnpNumber(val)
+	},
+	"fixed.14.4": func(v interface{}) (string, error) {
+		val, ok := v.(float64)
+		if !ok {
+			return "", fmt.Errorf("expected type float64, received: %s", reflect.TypeOf(v))
+		}
+		return marshalUpnpFixed14_4(val)
+	},
+	"float": func(v interface{}) (string, error) {
+		val, ok := v.(float64)
+		if !ok {
+			return "", fmt.Errorf("expected type float64, received: %s", reflect.TypeOf(v))
+		}
+		return marshalUpnpFloat(val)
+	},
+	"char": func(v interface{}) (string, error) {
+		val, ok := v.(rune)
+		if !ok {
+			return "", fmt.Errorf("expected type rune, received: %s", reflect.TypeOf(v))
+		}
+		return marshalUpnpChar(val)
+	},
+	"string": func(v interface{}) (string, error) {
+		val, ok := v.(string)
+		if !ok {
+			return "", fmt.Errorf("expected type string, received: %s", reflect.TypeOf(v))
+		}
+		return marshalUpnpString(val)
+	},
+	"date": func(v interface{}) (string, error) {
+		val, ok := v.(time.Time)
+		if !ok {
+			return "", fmt.Errorf("expected type time.Time, received: %s", reflect.TypeOf(v))
+		}
+		return marshalUpnpDate(val)
+	},
+	"dateTime": func(v interface{}) (string, error) {
+		val, ok := v.(time.Time)
+		if !ok {
+			return "", fmt.Errorf("expected type time.Time, received: %s", reflect.TypeOf(v))
+		}
+		return marshalUpnpDateTime(val)
+	},
+	"dateTime.tz": func(v interface{}) (string, error) {
+		val, ok := v.(time.Time)
+		if !ok {
+			return "", fmt.Errorf("expected type time.Time, received: %s", reflect.TypeOf(v))
+		}
+		return marshalUpnpDateTimeTz(val)
+	},
+	"time": func(v interface{}) (string, error) {
+		val, ok := v.(timeOfDay)
+		if !ok {
+			return "", fmt.Errorf("expected type timeOfDay, received: %s", reflect.TypeOf(v))
+		}
+		return marshalUpnpTimeOfDay(val)
+	},
+	"time.tz": func(v interface{}) (string, error) {
+		val, ok := v.(timeOfDay)
+		if !ok {
+			return "", fmt.Errorf("expected type timeOfDay, received: %s", reflect.TypeOf(v))
+		}
+		return marshalUpnpTimeOfDayTz(val)
+	},
+	"boolean": func(v interface{}) (string, error) {
+		val, ok := v.(bool)
+		if !ok {
+			return "", fmt.Errorf("expected type bool, received: %s", reflect.TypeOf(v))
+		}
+		return marshalUpnpBoolean(val)
+	},
+	"bin.base64": func(v interface{}) (string, error) {
+		val, ok := v.([]byte)
+		if !ok {
+			return "", fmt.Errorf("expected type []byte, received: %s", reflect.TypeOf(v))
+		}
+		return marshalUpnpBinBase64(val)
+	},
+	"bin.hex": func(v interface{}) (string, error) {
+		val, ok := v.([]byte)
+		if !ok {
+			return "", fmt.Errorf("expected type []byte, received: %s", reflect.TypeOf(v))
+		}
+		return marshalUpnpBinHex(val)
+	},
+	"uri": func(v interface{}) (string, error) {
+		val, ok := v.(*url.URL)
+		if !ok {
+			return "", fmt.Errorf("expected type *url.URL, received: %s", reflect.TypeOf(v))
+		}
+		return marshalUpnpURI(val)
+	},
+}
+
 
 // upnpUI1 is the representation of the UPnP type ui1 as Golang type
 type upnpUI1 struct {